@@ -0,0 +1,309 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/spf13/cobra"
+	"github.com/wzv5/pping/pkg/ping"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		s     string
+		ports []uint16
+	}{
+		{"80", []uint16{80}},
+		{"22,80,443", []uint16{22, 80, 443}},
+		{"8000-8003", []uint16{8000, 8001, 8002, 8003}},
+		{"80, 79-81,80", []uint16{80, 79, 81}},
+		{"65535-65535", []uint16{65535}},
+	}
+	for _, tt := range tests {
+		ports, err := parsePorts(tt.s)
+		if err != nil {
+			t.Fatal(tt.s, err)
+		}
+		if !slices.Equal(ports, tt.ports) {
+			t.Fatal(tt.s, ports)
+		}
+	}
+	for _, s := range []string{"", "http", "80-", "81-80", "65536", "1,,2"} {
+		if _, err := parsePorts(s); err == nil {
+			t.Fatal(s)
+		}
+	}
+}
+
+func TestParseCandidates(t *testing.T) {
+	ips, err := parseCandidates([]string{"10.0.0.1", "10.0.0.0/30", "2001:db8::1", "10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ip := range ips {
+		got = append(got, ip.String())
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"}; !slices.Equal(got, want) {
+		t.Fatal(got)
+	}
+	for _, s := range []string{"example.com", "10.0.0.0/33", "10.0.0.0/8"} {
+		if _, err := parseCandidates([]string{s}); err == nil {
+			t.Fatal(s)
+		}
+	}
+}
+
+func TestSortRank(t *testing.T) {
+	newresult := func(name string, times []int, failed int, speed float64) *rankResult {
+		r := &rankResult{ip: net.ParseIP(name)}
+		for _, v := range times {
+			r.s.sent++
+			r.s.add(v)
+			r.speedsum += speed
+		}
+		r.s.sent += failed
+		r.s.failed += failed
+		return r
+	}
+	results := []*rankResult{
+		newresult("10.0.0.1", []int{50, 50}, 0, 100),
+		newresult("10.0.0.2", []int{10}, 1, 900),
+		newresult("10.0.0.3", []int{20, 30}, 0, 50),
+		newresult("10.0.0.4", nil, 2, 0),
+	}
+	order := func() []string {
+		var s []string
+		for _, r := range results {
+			s = append(s, r.ip.String())
+		}
+		return s
+	}
+	sortRank(results, false)
+	if want := []string{"10.0.0.3", "10.0.0.1", "10.0.0.2", "10.0.0.4"}; !slices.Equal(order(), want) {
+		t.Fatal(order())
+	}
+	sortRank(results, true)
+	if want := []string{"10.0.0.1", "10.0.0.3", "10.0.0.2", "10.0.0.4"}; !slices.Equal(order(), want) {
+		t.Fatal(order())
+	}
+}
+
+func TestMtrStatistics(t *testing.T) {
+	router, target := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.9")
+	m := &mtr{host: "target", ip: target}
+	for i := 0; i < 5; i++ {
+		m.hops = append(m.hops, &mtrHop{})
+	}
+	exceeded := func(ms int) *ping.IcmpPingResult {
+		return &ping.IcmpPingResult{Time: ms, IP: router, Err: fmt.Errorf("%s: %w", router, ping.ErrTimeExceeded)}
+	}
+	timeout := &ping.IcmpPingResult{Err: ping.ErrTimeout}
+	reply := func(ms int) *ping.IcmpPingResult {
+		return &ping.IcmpPingResult{Time: ms, IP: target}
+	}
+	rounds := [][]*ping.IcmpPingResult{
+		{exceeded(10), timeout, timeout, timeout, timeout},
+		{exceeded(20), timeout, reply(30), reply(31), reply(32)},
+		{exceeded(30), timeout, reply(50)},
+		{timeout, timeout, timeout},
+	}
+	for _, r := range rounds {
+		if err := m.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := m.report()
+	if !report.Reached || len(report.Hops) != 3 {
+		t.Fatal(report)
+	}
+	hop := report.Hops[0]
+	if hop.Host != "10.0.0.1" || hop.Sent != 4 || hop.Lost != 1 || hop.Loss != 25 || hop.Best != 10 || hop.Worst != 30 || hop.Avg != 20 || hop.Last != 30 {
+		t.Fatal(hop)
+	}
+	if math.Abs(hop.StdDev-math.Sqrt(200.0/3)) > 1e-9 {
+		t.Fatal(hop.StdDev)
+	}
+	if hop := report.Hops[1]; hop.Host != "" || hop.Loss != 100 {
+		t.Fatal(hop)
+	}
+	if hop := report.Hops[2]; hop.Host != "10.0.0.9" || hop.Sent != 4 || hop.Lost != 2 || hop.Avg != 40 {
+		t.Fatal(hop)
+	}
+	// 其他错误中止探测
+	if err := m.add([]*ping.IcmpPingResult{{Err: errors.New("socket error")}}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestExpandCIDR(t *testing.T) {
+	tests := []struct {
+		s     string
+		n     int
+		first string
+		last  string
+	}{
+		{"10.0.0.0/24", 254, "10.0.0.1", "10.0.0.254"},
+		{"10.0.0.77/30", 2, "10.0.0.77", "10.0.0.78"},
+		{"10.0.0.0/31", 2, "10.0.0.0", "10.0.0.1"},
+		{"10.0.0.5/32", 1, "10.0.0.5", "10.0.0.5"},
+		{"10.0.0.0/16", 65534, "10.0.0.1", "10.0.255.254"},
+		{"2001:db8::/126", 4, "2001:db8::", "2001:db8::3"},
+		{"2001:db8::ff00/120", 256, "2001:db8::ff00", "2001:db8::ffff"},
+	}
+	for _, tt := range tests {
+		ips, err := expandCIDR(tt.s)
+		if err != nil {
+			t.Fatal(tt.s, err)
+		}
+		if len(ips) != tt.n || ips[0].String() != tt.first || ips[len(ips)-1].String() != tt.last {
+			t.Fatal(tt.s, len(ips), ips[0], ips[len(ips)-1])
+		}
+	}
+	for _, s := range []string{"10.0.0.0/15", "2001:db8::/64", "10.0.0.0", "10.0.0.0/33"} {
+		if _, err := expandCIDR(s); err == nil {
+			t.Fatal(s)
+		}
+	}
+}
+
+func TestRunSweep(t *testing.T) {
+	const n, jobs = 50, 4
+	var mu sync.Mutex
+	running, peak := 0, 0
+	var got []int
+	runSweep(context.Background(), n, jobs, 0, func(ctx context.Context, i int) int {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		// 后开始的探测先完成，结果仍需按顺序输出
+		time.Sleep(time.Duration(n-i) * 100 * time.Microsecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return i * i
+	}, func(i int, r int) {
+		if r != i*i {
+			t.Error(i, r)
+		}
+		got = append(got, i)
+	})
+	if len(got) != n || !slices.IsSorted(got) {
+		t.Fatal(got)
+	}
+	if peak > jobs {
+		t.Fatal("peak", peak)
+	}
+
+	// 限速
+	start := time.Now()
+	runSweep(context.Background(), 5, jobs, 100, func(ctx context.Context, i int) int { return i }, func(int, int) {})
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatal("rate", d)
+	}
+
+	// 取消后不再开始新的探测，也不再输出
+	ctx, cancel := context.WithCancel(context.Background())
+	var started atomic.Int32
+	output := 0
+	runSweep(ctx, n, 1, 0, func(ctx context.Context, i int) int {
+		if started.Add(1) == 3 {
+			cancel()
+		}
+		return i
+	}, func(int, int) { output++ })
+	if started.Load() > 4 || output > 3 {
+		t.Fatal(started.Load(), output)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"1000", 1000},
+		{"10K", 10 << 10},
+		{"10k", 10 << 10},
+		{"5M", 5 << 20},
+		{"2G", 2 << 30},
+	}
+	for _, tt := range tests {
+		n, err := parseSize(tt.s)
+		if err != nil || n != tt.want {
+			t.Fatal(tt.s, n, err)
+		}
+	}
+	for _, s := range []string{"", "M", "0", "-1K", "1.5M", "10T"} {
+		if _, err := parseSize(s); err == nil {
+			t.Fatal(s)
+		}
+	}
+}
+
+func TestHttpCompare(t *testing.T) {
+	var mu sync.Mutex
+	var protos []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		protos = append(protos, r.Proto)
+		mu.Unlock()
+		w.Header().Set("X-Cache", "HIT")
+	})
+	// HTTP/3 与 TCP 使用相同的端口号
+	var srv *httptest.Server
+	var udp net.PacketConn
+	for i := 0; i < 10 && udp == nil; i++ {
+		srv = httptest.NewUnstartedServer(h)
+		srv.EnableHTTP2 = true
+		srv.StartTLS()
+		var err error
+		udp, err = net.ListenPacket("udp", srv.Listener.Addr().String())
+		if err != nil {
+			srv.Close()
+		}
+	}
+	if udp == nil {
+		t.Skip("no free port for both tcp and udp")
+	}
+	defer srv.Close()
+	h3srv := &http3.Server{
+		Handler:   h,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: srv.TLS.Certificates}),
+	}
+	go h3srv.Serve(udp)
+	defer h3srv.Close()
+
+	saved, savedglobal := httpflag, globalflag
+	defer func() {
+		httpflag, globalflag = saved, savedglobal
+	}()
+	httpflag = httpFlags{method: "GET", timeout: 3 * time.Second, insecure: true, showheader: true}
+	globalflag.n, globalflag.t, globalflag.i = 3, false, 0
+	if err := runhttpcompare(&cobra.Command{}, srv.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 预热一次，之后每轮轮换顺序
+	want := []string{
+		"HTTP/1.1", "HTTP/2.0", "HTTP/3.0",
+		"HTTP/1.1", "HTTP/2.0", "HTTP/3.0",
+		"HTTP/2.0", "HTTP/3.0", "HTTP/1.1",
+		"HTTP/3.0", "HTTP/1.1", "HTTP/2.0",
+	}
+	if !slices.Equal(protos, want) {
+		t.Fatal(protos)
+	}
+}
@@ -1,9 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/wzv5/pping/pkg/ping"
@@ -16,19 +21,31 @@ type dnsFlags struct {
 	timeout  time.Duration
 	tcp      bool
 	tls      bool
+	quic     bool
 	qtype    string
 	domain   string
 	insecure bool
+	rcode    string
+	answer   string
+	dnssec   bool
+	subnet   string
+	nsid     bool
+	bufsize  uint16
+	cookie   bool
+	random   bool
+	names    []string
+	namefile string
+	zone     string
 }
 
 var dnsflag dnsFlags
 
 func addDnsCommand() {
 	var cmd = &cobra.Command{
-		Use:   "dns <host>",
+		Use:   "dns [host]",
 		Short: "dns ping",
-		Long:  "dns ping",
-		Args:  cobra.ExactArgs(1),
+		Long:  "dns ping, or with --zone check the authoritative servers of a zone, host is then the resolver used to find them, the system resolver if omitted",
+		Args:  cobra.RangeArgs(0, 1),
 		RunE:  rundns,
 	}
 
@@ -36,17 +53,37 @@ func addDnsCommand() {
 	cmd.Flags().Uint16VarP(&dnsflag.port, "port", "p", 0, "port")
 	cmd.Flags().BoolVar(&dnsflag.tcp, "tcp", false, "use TCP")
 	cmd.Flags().BoolVar(&dnsflag.tls, "tls", false, "use DNS-over-TLS")
+	cmd.Flags().BoolVar(&dnsflag.quic, "quic", false, "use DNS-over-QUIC")
 	cmd.Flags().StringVar(&dnsflag.qtype, "type", "NS", "A, AAAA, NS, ...")
 	cmd.Flags().StringVar(&dnsflag.domain, "domain", ".", "domain")
 	cmd.Flags().BoolVarP(&dnsflag.insecure, "insecure", "k", false, "allow insecure server connections")
+	cmd.Flags().StringVar(&dnsflag.rcode, "expect-rcode", "", "expected response code, e.g. NOERROR")
+	cmd.Flags().StringVar(&dnsflag.answer, "expect-answer", "", "expected answer, IP or regular expression")
+	cmd.Flags().BoolVar(&dnsflag.dnssec, "dnssec", false, "set the DNSSEC OK (DO) bit")
+	cmd.Flags().StringVar(&dnsflag.subnet, "subnet", "", "EDNS client subnet, e.g. 1.2.3.0/24")
+	cmd.Flags().BoolVar(&dnsflag.nsid, "nsid", false, "request NSID")
+	cmd.Flags().Uint16Var(&dnsflag.bufsize, "bufsize", 0, "EDNS UDP buffer size (default 1232)")
+	cmd.Flags().BoolVar(&dnsflag.cookie, "cookie", false, "send DNS cookie")
+	cmd.Flags().BoolVar(&dnsflag.random, "random", false, "prefix each query with a random label to bypass the cache")
+	cmd.Flags().StringSliceVar(&dnsflag.names, "names", nil, "cycle through the given domains to bypass the cache")
+	cmd.Flags().StringVar(&dnsflag.namefile, "names-file", "", "read domains for --names from file, one per line")
+	cmd.Flags().StringVar(&dnsflag.zone, "zone", "", "check SOA consistency of all authoritative servers of the zone, optionally also --type and --domain")
 
 	rootCmd.AddCommand(cmd)
 }
 
 func rundns(cmd *cobra.Command, args []string) error {
-	host := args[0]
+	if len(args) == 0 && dnsflag.zone == "" {
+		return fmt.Errorf("requires a host unless --zone is set")
+	}
+	host := ""
+	if len(args) != 0 {
+		host = args[0]
+	}
 	Net := "udp"
-	if dnsflag.tls {
+	if dnsflag.quic {
+		Net = "quic"
+	} else if dnsflag.tls {
 		Net = "tcp-tls"
 	} else if dnsflag.tcp {
 		Net = "tcp"
@@ -55,16 +92,120 @@ func rundns(cmd *cobra.Command, args []string) error {
 		switch Net {
 		case "udp", "tcp":
 			dnsflag.port = 53
-		case "tcp-tls":
+		case "tcp-tls", "quic":
 			dnsflag.port = 853
 		}
 	}
-	fmt.Printf("Ping %s://%s:\n", Net, net.JoinHostPort(host, strconv.Itoa(int(dnsflag.port))))
 	p := ping.NewDnsPing(host, dnsflag.timeout)
 	p.Port = dnsflag.port
 	p.Net = Net
 	p.Type = dnsflag.qtype
 	p.Domain = dnsflag.domain
 	p.Insecure = dnsflag.insecure
+	p.ExpectRcode = dnsflag.rcode
+	p.DNSSEC = dnsflag.dnssec
+	p.ClientSubnet = dnsflag.subnet
+	p.NSID = dnsflag.nsid
+	p.UDPSize = dnsflag.bufsize
+	p.Cookie = dnsflag.cookie
+	p.RandomPrefix = dnsflag.random
+	p.Names = dnsflag.names
+	p.TOS = globalflag.tos
+	if dnsflag.answer != "" {
+		// IP 以外的值作为正则表达式
+		if p.ExpectIP = net.ParseIP(dnsflag.answer); p.ExpectIP == nil {
+			var err error
+			p.ExpectAnswer, err = regexp.Compile(dnsflag.answer)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if dnsflag.namefile != "" {
+		names, err := readLines(dnsflag.namefile)
+		if err != nil {
+			return err
+		}
+		p.Names = append(p.Names, names...)
+	}
+	if dnsflag.zone != "" {
+		return runzone(cmd, p)
+	}
+	fmt.Printf("Ping %s://%s:\n", Net, net.JoinHostPort(host, strconv.Itoa(int(dnsflag.port))))
+	if p.RandomPrefix || len(p.Names) > 0 {
+		return RunPing(p, &dnsCacheStatistics{})
+	}
 	return RunPing(p)
 }
+
+func runzone(cmd *cobra.Command, p *ping.DnsPing) error {
+	z := ping.NewDnsZoneCheck(p, dnsflag.zone)
+	if cmd.Flags().Changed("domain") {
+		z.Domain = dnsflag.domain
+		// --type 的默认值为 NS，未指定时使用 NewDnsZoneCheck 的默认值
+		if cmd.Flags().Changed("type") {
+			z.Type = dnsflag.qtype
+		}
+	}
+	z.IPv4 = globalflag.ipv4
+	z.IPv6 = globalflag.ipv6
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	servers, err := z.CheckContext(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Zone %s (%d servers):\n", dnsflag.zone, len(servers))
+	failed := 0
+	for _, s := range servers {
+		mark := " "
+		if !s.OK() {
+			mark = "!"
+			failed++
+		}
+		fmt.Printf("%s %v\n", mark, s)
+	}
+	if failed != 0 {
+		fmt.Printf("\n\t%d of %d servers have problems\n", failed, len(servers))
+		return ErrPing
+	}
+	return nil
+}
+
+func readLines(name string) ([]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// 缓存命中与未命中的延迟对比
+type dnsCacheStatistics struct {
+	cached, uncached statistics
+}
+
+func (s *dnsCacheStatistics) append(result ping.IPingResult) {
+	r, ok := result.(*ping.DnsPingResult)
+	if !ok || r.Err != nil {
+		return
+	}
+	s.cached.add(r.CachedTime)
+	s.uncached.add(r.Time)
+}
+
+func (s *dnsCacheStatistics) print() {
+	if s.uncached.ok == 0 {
+		return
+	}
+	fmt.Printf("\tcached:   min = %d ms, max = %d ms, avg = %d ms\n", s.cached.min, s.cached.max, s.cached.total/s.cached.ok)
+	fmt.Printf("\tuncached: min = %d ms, max = %d ms, avg = %d ms\n", s.uncached.min, s.uncached.max, s.uncached.total/s.uncached.ok)
+}
@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +28,14 @@ type httpFlags struct {
 	refer              string
 	ua                 string
 	http3              bool
+	maxbytes           string
+	duration           time.Duration
+	uploadsize         string
+	uploadfile         string
+	auto               bool
+	httpsrr            string
+	compare            bool
+	showheader         bool
 }
 
 var httpflag httpFlags
@@ -42,6 +57,17 @@ func addHttpCommand() {
 	cmd.Flags().StringVarP(&httpflag.refer, "referrer", "r", "", "Referer header")
 	cmd.Flags().StringVarP(&httpflag.ua, "useragent", "u", "", "User-Agent header")
 	cmd.Flags().BoolVarP(&httpflag.http3, "http3", "3", false, "use HTTP/3")
+	cmd.Flags().StringVar(&httpflag.maxbytes, "max-bytes", "", "stop reading the body after this many bytes, e.g. 10M")
+	cmd.Flags().DurationVar(&httpflag.duration, "duration", 0, "stop reading the body after this duration, --timeout then only limits the time to the response headers")
+	cmd.Flags().StringVar(&httpflag.uploadsize, "upload-size", "", "upload this many random bytes, e.g. 10M, the method defaults to POST")
+	cmd.Flags().StringVar(&httpflag.uploadfile, "upload-file", "", "upload the file, the method defaults to POST")
+	cmd.Flags().BoolVar(&httpflag.auto, "auto", false, "start with TCP and switch to HTTP/3 when advertised by Alt-Svc, like browsers")
+	cmd.Flags().StringVar(&httpflag.httpsrr, "https-rr", "", "with --auto, also look up the HTTPS DNS record via this resolver")
+	cmd.MarkFlagsMutuallyExclusive("upload-size", "upload-file")
+	cmd.Flags().BoolVar(&httpflag.compare, "compare", false, "send each request over HTTP/1.1, HTTP/2 and HTTP/3 and compare them side by side, https only")
+	cmd.Flags().BoolVar(&httpflag.showheader, "show-header", false, "print the cdn related response headers of each request")
+	cmd.MarkFlagsMutuallyExclusive("http3", "auto", "compare")
+	cmd.MarkFlagsMutuallyExclusive("nohttp2", "compare")
 	rootCmd.AddCommand(cmd)
 }
 
@@ -57,7 +83,21 @@ func runhttp(cmd *cobra.Command, args []string) error {
 			return errors.New("parse IP failed")
 		}
 	}
+	if httpflag.compare {
+		if !strings.HasPrefix(url, "https://") {
+			return errors.New("--compare requires an https url")
+		}
+		return runhttpcompare(cmd, url, ip)
+	}
 	fmt.Printf("Ping %s:\n", url)
+	p, err := newHttpPing(cmd, url, ip)
+	if err != nil {
+		return err
+	}
+	return RunPing(p, &httpStatistics{}, &edgeStatistics{show: httpflag.showheader})
+}
+
+func newHttpPing(cmd *cobra.Command, url string, ip net.IP) (*ping.HttpPing, error) {
 	p := ping.NewHttpPing(httpflag.method, url, httpflag.timeout)
 	p.DisableHttp2 = httpflag.disablehttp2
 	p.DisableCompression = httpflag.disablecompression
@@ -66,5 +106,265 @@ func runhttp(cmd *cobra.Command, args []string) error {
 	p.UserAgent = httpflag.ua
 	p.IP = ip
 	p.Http3 = httpflag.http3
-	return RunPing(p)
+	p.AutoHttp3 = httpflag.auto
+	if httpflag.httpsrr != "" {
+		if !httpflag.auto {
+			return nil, errors.New("--https-rr requires --auto")
+		}
+		p.HttpsResolver = ping.NewDnsPing(httpflag.httpsrr, httpflag.timeout)
+	}
+	p.TOS = globalflag.tos
+	if httpflag.maxbytes != "" {
+		n, err := parseSize(httpflag.maxbytes)
+		if err != nil {
+			return nil, err
+		}
+		p.MaxBytes = n
+	}
+	p.MaxDuration = httpflag.duration
+	if httpflag.uploadsize != "" {
+		n, err := parseSize(httpflag.uploadsize)
+		if err != nil {
+			return nil, err
+		}
+		p.UploadSize = n
+	}
+	p.UploadFile = httpflag.uploadfile
+	if (p.UploadSize > 0 || p.UploadFile != "") && !cmd.Flags().Changed("method") {
+		p.Method = "POST"
+	}
+	return p, nil
+}
+
+// 每轮通过 HTTP/1.1、HTTP/2 和 HTTP/3 各请求一次，每轮轮换顺序以避免先后带来的偏差
+func runhttpcompare(cmd *cobra.Command, url string, ip net.IP) error {
+	names := []string{"HTTP/1.1", "HTTP/2", "HTTP/3"}
+	protos := []string{"HTTP/1.1", "HTTP/2.0", "HTTP/3.0"}
+	pings := make([]*ping.HttpPing, len(names))
+	for i := range pings {
+		p, err := newHttpPing(cmd, url, ip)
+		if err != nil {
+			return err
+		}
+		p.DisableHttp2 = i == 0
+		p.Http3 = i == 2
+		pings[i] = p
+	}
+	fmt.Printf("Compare %s:\n", url)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if globalflag.n > 1 {
+		// 预热，与 RunPing 相同
+		for _, p := range pings {
+			p.PingContext(ctx)
+		}
+	}
+	stats := make([]statistics, len(names))
+	// 所有协议共用，响应头及 POP、缓存统计与 RunPing 相同
+	edge := &edgeStatistics{show: httpflag.showheader}
+	// 实际协商的协议与请求的不同，如服务器不支持 HTTP/2
+	mismatch := make([]int, len(names))
+	for round := 1; (round <= globalflag.n || globalflag.t) && ctx.Err() == nil; round++ {
+		if round > 1 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(globalflag.i):
+			}
+		}
+		for k := range names {
+			if ctx.Err() != nil {
+				break
+			}
+			i := (round - 1 + k) % len(names)
+			result := pings[i].PingContext(ctx)
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("[%d] %-8s  %v\n", round, names[i], result)
+			stats[i].append(result)
+			edge.append(result)
+			if r := result.(*ping.HttpPingResult); r.Err == nil && r.Proto != protos[i] {
+				mismatch[i]++
+			}
+		}
+	}
+
+	fmt.Println()
+	row := func(name string, f func(i int) string) {
+		line := fmt.Sprintf("\t%-8s", name)
+		for i := range names {
+			line += fmt.Sprintf("  %12s", f(i))
+		}
+		fmt.Println(line)
+	}
+	row("", func(i int) string { return names[i] })
+	row("sent", func(i int) string { return strconv.Itoa(stats[i].sent) })
+	row("loss", func(i int) string {
+		if stats[i].sent == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(stats[i].failed)/float64(stats[i].sent))
+	})
+	ms := func(f func(s *statistics) float64) func(i int) string {
+		return func(i int) string {
+			if stats[i].ok == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%.0f ms", f(&stats[i]))
+		}
+	}
+	row("min", ms(func(s *statistics) float64 { return float64(s.min) }))
+	row("avg", ms(func(s *statistics) float64 { return s.avg() }))
+	row("max", ms(func(s *statistics) float64 { return float64(s.max) }))
+	row("stddev", ms(func(s *statistics) float64 { return s.stddev() }))
+	for i, n := range mismatch {
+		if n > 0 {
+			fmt.Printf("\t%s: %d responses used another protocol\n", names[i], n)
+		}
+	}
+	edge.print()
+
+	for i := range stats {
+		if stats[i].sent == 0 || stats[i].failed != 0 {
+			return ErrPing
+		}
+	}
+	return nil
+}
+
+// 解析大小，支持 K、M、G 后缀，以 1024 为进制
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("invalid size: empty")
+	}
+	mul := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mul = 1 << 10
+	case "M":
+		mul = 1 << 20
+	case "G":
+		mul = 1 << 30
+	}
+	if mul != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return n * mul, nil
+}
+
+// 下载及上传速度统计
+type httpStatistics struct {
+	download, upload speedStatistics
+}
+
+func (s *httpStatistics) append(result ping.IPingResult) {
+	r, ok := result.(*ping.HttpPingResult)
+	if !ok || r.Err != nil {
+		return
+	}
+	if r.Length > 0 {
+		s.download.add(r.Speed)
+	}
+	if r.Uploaded > 0 {
+		s.upload.add(r.UploadSpeed)
+	}
+}
+
+func (s *httpStatistics) print() {
+	s.download.print("download")
+	s.upload.print("upload")
+}
+
+type speedStatistics struct {
+	n             int
+	min, max, sum float64
+}
+
+func (s *speedStatistics) add(v float64) {
+	if s.n == 0 || v < s.min {
+		s.min = v
+	}
+	if v > s.max {
+		s.max = v
+	}
+	s.sum += v
+	s.n++
+}
+
+func (s *speedStatistics) print(name string) {
+	if s.n == 0 {
+		return
+	}
+	fmt.Printf("\t%s speed: min = %s, max = %s, avg = %s\n", name, ping.FormatSpeed(s.min), ping.FormatSpeed(s.max), ping.FormatSpeed(s.sum/float64(s.n)))
+}
+
+// 统计响应的 CDN 节点及缓存命中率
+type edgeStatistics struct {
+	show bool
+	pops map[string]*statistics
+	// 按首次出现的顺序
+	order     []string
+	hit, miss int
+	other     int
+}
+
+func (s *edgeStatistics) append(result ping.IPingResult) {
+	r, ok := result.(*ping.HttpPingResult)
+	if !ok || r.Err != nil {
+		return
+	}
+	if s.show {
+		for _, k := range ping.EdgeHeaders {
+			for _, v := range r.Header.Values(k) {
+				fmt.Printf("\t%s: %s\n", k, v)
+			}
+		}
+	}
+	if pop := r.Pop(); pop != "" {
+		if s.pops == nil {
+			s.pops = make(map[string]*statistics)
+		}
+		if s.pops[pop] == nil {
+			s.pops[pop] = &statistics{}
+			s.order = append(s.order, pop)
+		}
+		s.pops[pop].add(r.Time)
+	}
+	switch r.CacheStatus() {
+	case "HIT":
+		s.hit++
+	case "MISS":
+		s.miss++
+	case "":
+	default:
+		s.other++
+	}
+}
+
+func (s *edgeStatistics) print() {
+	if len(s.order) > 0 {
+		order := slices.Clone(s.order)
+		sort.SliceStable(order, func(i, j int) bool {
+			return s.pops[order[i]].ok > s.pops[order[j]].ok
+		})
+		var parts []string
+		for _, pop := range order {
+			st := s.pops[pop]
+			parts = append(parts, fmt.Sprintf("%s %d (avg %.0f ms)", pop, st.ok, st.avg()))
+		}
+		fmt.Printf("\tpop: %s\n", strings.Join(parts, ", "))
+	}
+	if n := s.hit + s.miss; n > 0 {
+		line := fmt.Sprintf("\tcache: hit = %d, miss = %d (%d%% hit)", s.hit, s.miss, 100*s.hit/n)
+		if s.other > 0 {
+			line += fmt.Sprintf(", other = %d", s.other)
+		}
+		fmt.Println(line)
+	}
 }
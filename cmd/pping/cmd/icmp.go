@@ -1,7 +1,13 @@
 package cmd
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/wzv5/pping/pkg/ping"
@@ -14,6 +20,14 @@ type icmpFlags struct {
 	timeout    time.Duration
 	ttl        int
 	size       int
+	df         bool
+	pattern    string
+	timestamp  bool
+	sizefrom   int
+	sizeto     int
+	sizestep   int
+	jobs       int
+	rate       float64
 }
 
 var icmpflag icmpFlags
@@ -22,7 +36,7 @@ func addIcmpCommand() {
 	var cmd = &cobra.Command{
 		Use:   "icmp <host>",
 		Short: "icmp ping",
-		Long:  "icmp ping",
+		Long:  "icmp ping, or ping each host once when given a CIDR such as 10.0.0.0/24",
 		Args:  cobra.ExactArgs(1),
 		RunE:  runicmp,
 	}
@@ -31,12 +45,19 @@ func addIcmpCommand() {
 	cmd.Flags().BoolVarP(&icmpflag.privileged, "privileged", "p", false, "privileged")
 	cmd.Flags().IntVarP(&icmpflag.ttl, "ttl", "l", 0, "time to live")
 	cmd.Flags().IntVarP(&icmpflag.size, "size", "s", 0, "send buffer size")
+	cmd.Flags().BoolVar(&icmpflag.df, "df", false, "set the don't fragment bit")
+	cmd.Flags().StringVar(&icmpflag.pattern, "pattern", "", "fill the payload with the hex pattern, e.g. ff00")
+	cmd.Flags().BoolVar(&icmpflag.timestamp, "timestamp", false, "embed the send time in the payload")
+	cmd.Flags().IntVar(&icmpflag.sizefrom, "size-from", 0, "sweep mode, smallest send buffer size")
+	cmd.Flags().IntVar(&icmpflag.sizeto, "size-to", 0, "sweep mode, largest send buffer size")
+	cmd.Flags().IntVar(&icmpflag.sizestep, "size-step", 100, "sweep mode, size increment")
+	cmd.Flags().IntVarP(&icmpflag.jobs, "jobs", "j", 64, "number of hosts pinged concurrently in a CIDR range")
+	cmd.Flags().Float64Var(&icmpflag.rate, "rate", 0, "maximum number of hosts pinged per second in a CIDR range, 0 for no limit")
 	rootCmd.AddCommand(cmd)
 }
 
 func runicmp(cmd *cobra.Command, args []string) error {
 	host := args[0]
-	fmt.Printf("Ping %s:\n", host)
 	p := ping.NewIcmpPing(host, icmpflag.timeout)
 	p.Privileged = icmpflag.privileged
 	if icmpflag.ttl > 0 {
@@ -45,5 +66,137 @@ func runicmp(cmd *cobra.Command, args []string) error {
 	if icmpflag.size > 0 {
 		p.Size = icmpflag.size
 	}
-	return RunPing(p)
+	p.DontFragment = icmpflag.df
+	p.TOS = globalflag.tos
+	if icmpflag.pattern != "" {
+		pattern, err := hex.DecodeString(icmpflag.pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		p.Pattern = pattern
+	}
+	p.Timestamp = icmpflag.timestamp
+	if isCIDR(host) {
+		if icmpflag.sizefrom > 0 || icmpflag.sizeto > 0 {
+			return errors.New("size sweep requires a single host")
+		}
+		return runicmpcidr(host, p)
+	}
+	fmt.Printf("Ping %s:\n", host)
+	if icmpflag.sizefrom > 0 || icmpflag.sizeto > 0 {
+		return runicmpsweep(p)
+	}
+	p.OnLateReply = func(r *ping.IcmpPingResult) {
+		log.Printf("    %v\n", r)
+	}
+	return RunPing(p, &icmpStatistics{p})
+}
+
+// 依次使用不同大小的包 ping，每个大小发送 -c 次，输出各自的延迟及丢包率
+func runicmpsweep(p *ping.IcmpPing) error {
+	from, to, step := icmpflag.sizefrom, icmpflag.sizeto, icmpflag.sizestep
+	if from <= 0 {
+		from = p.Size
+	}
+	if to <= 0 {
+		to = from
+	}
+	if from > to || step <= 0 {
+		return errors.New("invalid size range")
+	}
+	defer p.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	failed := false
+	for size := from; size <= to; size += step {
+		p.Size = size
+		s := statistics{}
+		for i := 0; i < globalflag.n; i++ {
+			if i > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(globalflag.i):
+				}
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			r := p.PingContext(ctx)
+			if ctx.Err() != nil {
+				return nil
+			}
+			s.append(r)
+		}
+		if s.failed != 0 {
+			failed = true
+		}
+		if s.ok > 0 {
+			fmt.Printf("size = %5d: sent = %d, failed = %d (%d%%), min = %d ms, max = %d ms, avg = %.1f ms\n", size, s.sent, s.failed, 100*s.failed/s.sent, s.min, s.max, s.avg())
+		} else {
+			fmt.Printf("size = %5d: sent = %d, failed = %d (%d%%)\n", size, s.sent, s.failed, 100*s.failed/s.sent)
+		}
+		// 确保最后一个大小也被测试
+		if size < to && size+step > to {
+			size = to - step
+		}
+	}
+	if failed {
+		return ErrPing
+	}
+	return nil
+}
+
+// 使用共享的连接 ping CIDR 中的每个地址，输出有回复的地址。
+// 每个地址的 IcmpPing 复制自 p 的设置
+func runicmpcidr(cidr string, p *ping.IcmpPing) error {
+	ips, err := expandCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sweep %s, %d hosts:\n", cidr, len(ips))
+	engine := ping.NewIcmpEngine(p.Privileged)
+	defer engine.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	alive := 0
+	runSweep(ctx, len(ips), icmpflag.jobs, icmpflag.rate, func(ctx context.Context, i int) ping.IPingResult {
+		hp := ping.NewIcmpPing(ips[i].String(), p.Timeout)
+		hp.Privileged = p.Privileged
+		hp.Engine = engine
+		hp.TTL = p.TTL
+		hp.Size = p.Size
+		hp.DontFragment = p.DontFragment
+		hp.TOS = p.TOS
+		hp.Pattern = p.Pattern
+		hp.Timestamp = p.Timestamp
+		return hp.PingContext(ctx)
+	}, func(i int, r ping.IPingResult) {
+		if r.Error() == nil {
+			alive++
+			fmt.Printf("%-15s  %d ms\n", ips[i].String(), r.Result())
+		}
+	})
+	fmt.Println()
+	fmt.Printf("\talive = %d, total = %d\n", alive, len(ips))
+	if alive == 0 {
+		return ErrPing
+	}
+	return nil
+}
+
+type icmpStatistics struct {
+	p *ping.IcmpPing
+}
+
+func (s *icmpStatistics) append(ping.IPingResult) {}
+
+func (s *icmpStatistics) print() {
+	stats := s.p.Statistics()
+	if stats.Duplicates != 0 || stats.Late != 0 || stats.Reordered != 0 {
+		fmt.Printf("\tduplicates = %d, late = %d, out of order = %d\n", stats.Duplicates, stats.Late, stats.Reordered)
+	}
 }
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/wzv5/pping/pkg/ping"
+
+	"github.com/spf13/cobra"
+)
+
+type mtrFlags struct {
+	privileged bool
+	timeout    time.Duration
+	maxhops    int
+	size       int
+	json       bool
+}
+
+var mtrflag mtrFlags
+
+func addMtrCommand() {
+	var cmd = &cobra.Command{
+		Use:   "mtr <host>",
+		Short: "icmp traceroute with per-hop statistics",
+		Long:  "icmp traceroute with per-hop statistics, probes every hop once per round",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runmtr,
+	}
+
+	cmd.Flags().DurationVarP(&mtrflag.timeout, "timeout", "w", time.Second*2, "timeout")
+	cmd.Flags().BoolVarP(&mtrflag.privileged, "privileged", "p", false, "privileged")
+	cmd.Flags().IntVarP(&mtrflag.maxhops, "max-hops", "m", 30, "maximum number of hops")
+	cmd.Flags().IntVarP(&mtrflag.size, "size", "s", 0, "send buffer size")
+	cmd.Flags().BoolVar(&mtrflag.json, "json", false, "print the final report in JSON")
+	rootCmd.AddCommand(cmd)
+}
+
+type mtrHop struct {
+	p  *ping.IcmpPing
+	ip net.IP
+	s  statistics
+}
+
+func (h *mtrHop) append(r *ping.IcmpPingResult) {
+	h.s.sent++
+	if ip := r.Responder(); ip != nil {
+		h.ip = ip
+		h.s.add(r.Time)
+	} else {
+		h.s.failed++
+	}
+}
+
+type mtr struct {
+	host string
+	ip   net.IP
+	hops []*mtrHop
+	// 目标所在的跳数，未到达时为 0
+	final int
+	// 有回复的最远跳数
+	last int
+}
+
+func runmtr(cmd *cobra.Command, args []string) error {
+	host := args[0]
+	// 只解析一次，确保每一轮的目标相同
+	ip, err := ping.LookupFunc(host)
+	if err != nil {
+		return err
+	}
+
+	engine := ping.NewIcmpEngine(mtrflag.privileged)
+	defer engine.Close()
+	m := &mtr{host: host, ip: ip}
+	for ttl := 1; ttl <= mtrflag.maxhops; ttl++ {
+		p := ping.NewIcmpPing(ip.String(), mtrflag.timeout)
+		p.TTL = ttl
+		p.Privileged = mtrflag.privileged
+		p.Engine = engine
+		p.TOS = globalflag.tos
+		if mtrflag.size > 0 {
+			p.Size = mtrflag.size
+		}
+		m.hops = append(m.hops, &mtrHop{p: p})
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	// 仅在终端中刷新表格，否则只输出最终报告
+	live := !mtrflag.json && isTerminal(os.Stdout)
+	lines := 0
+	for i := 1; i <= globalflag.n || globalflag.t; i++ {
+		if err := m.round(ctx); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		if live {
+			if lines > 0 {
+				// 光标移回表格开头并清除之后的内容
+				fmt.Printf("\x1b[%dA\x1b[J", lines)
+			}
+			lines = m.print()
+		}
+
+		if i == globalflag.n && !globalflag.t {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(globalflag.i):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if mtrflag.json {
+		b, err := json.MarshalIndent(m.report(), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else if !live {
+		m.print()
+	}
+	if m.final == 0 {
+		return ErrPing
+	}
+	return nil
+}
+
+// 每一轮向所有跳并发发送一个探测
+func (m *mtr) round(ctx context.Context) error {
+	n := len(m.hops)
+	if m.final > 0 {
+		n = m.final
+	}
+	results := make([]*ping.IcmpPingResult, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = m.hops[i].p.PingContext(ctx).(*ping.IcmpPingResult)
+		}(i)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.add(results)
+}
+
+// 记录一轮的结果，results[i] 为第 i+1 跳的结果
+func (m *mtr) add(results []*ping.IcmpPingResult) error {
+	for i, r := range results {
+		// 除超时外的其他错误，如无法创建连接，继续探测也没有意义
+		if r.Responder() == nil && !errors.Is(r.Err, ping.ErrTimeout) {
+			return r.Err
+		}
+		m.hops[i].append(r)
+		if r.Responder() != nil {
+			m.last = max(m.last, i+1)
+			if !errors.Is(r.Err, ping.ErrTimeExceeded) && (m.final == 0 || i+1 < m.final) {
+				m.final = i + 1
+			}
+		}
+	}
+	return nil
+}
+
+// 需要显示的跳，到达目标后只显示到目标为止
+func (m *mtr) shown() []*mtrHop {
+	if m.final > 0 {
+		return m.hops[:m.final]
+	}
+	return m.hops[:m.last]
+}
+
+func (m *mtr) print() int {
+	fmt.Printf("Mtr %s (%s):\n", m.host, m.ip.String())
+	fmt.Printf("%-4s %-40s %6s %5s %5s %5s %5s %5s %6s\n", "", "Host", "Loss%", "Snt", "Last", "Avg", "Best", "Wrst", "StDev")
+	hops := m.shown()
+	for i, h := range hops {
+		host := "???"
+		if h.ip != nil {
+			host = h.ip.String()
+		}
+		fmt.Printf("%3d. %-40s %5.1f%% %5d %5d %5.0f %5d %5d %6.1f\n", i+1, host, 100*float64(h.s.failed)/float64(h.s.sent), h.s.sent, h.s.last, h.s.avg(), h.s.min, h.s.max, h.s.stddev())
+	}
+	return len(hops) + 2
+}
+
+type mtrHopReport struct {
+	TTL    int     `json:"ttl"`
+	Host   string  `json:"host"`
+	Sent   int     `json:"sent"`
+	Lost   int     `json:"lost"`
+	Loss   float64 `json:"loss"`
+	Last   int     `json:"last"`
+	Avg    float64 `json:"avg"`
+	Best   int     `json:"best"`
+	Worst  int     `json:"worst"`
+	StdDev float64 `json:"stddev"`
+}
+
+type mtrReport struct {
+	Host    string         `json:"host"`
+	IP      string         `json:"ip"`
+	Reached bool           `json:"reached"`
+	Hops    []mtrHopReport `json:"hops"`
+}
+
+func (m *mtr) report() *mtrReport {
+	r := &mtrReport{
+		Host:    m.host,
+		IP:      m.ip.String(),
+		Reached: m.final > 0,
+		Hops:    []mtrHopReport{},
+	}
+	for i, h := range m.shown() {
+		hop := mtrHopReport{
+			TTL:    i + 1,
+			Sent:   h.s.sent,
+			Lost:   h.s.failed,
+			Last:   h.s.last,
+			Avg:    h.s.avg(),
+			Best:   h.s.min,
+			Worst:  h.s.max,
+			StdDev: h.s.stddev(),
+		}
+		if h.ip != nil {
+			hop.Host = h.ip.String()
+		}
+		if h.s.sent > 0 {
+			hop.Loss = 100 * float64(h.s.failed) / float64(h.s.sent)
+		}
+		r.Hops = append(r.Hops, hop)
+	}
+	return r
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/wzv5/pping/pkg/ping"
+
+	"github.com/spf13/cobra"
+)
+
+type mtuFlags struct {
+	privileged bool
+	timeout    time.Duration
+	min        int
+	max        int
+	retries    int
+}
+
+var mtuflag mtuFlags
+
+func addMtuCommand() {
+	var cmd = &cobra.Command{
+		Use:   "mtu <host>",
+		Short: "path mtu discovery",
+		Long:  "path mtu discovery, sends icmp echo requests with the don't fragment bit set",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runmtu,
+	}
+
+	cmd.Flags().DurationVarP(&mtuflag.timeout, "timeout", "w", time.Second*2, "timeout")
+	cmd.Flags().BoolVarP(&mtuflag.privileged, "privileged", "p", false, "privileged")
+	cmd.Flags().IntVar(&mtuflag.min, "min", 0, "minimum mtu (default 68 for IPv4, 1280 for IPv6)")
+	cmd.Flags().IntVar(&mtuflag.max, "max", 1500, "maximum mtu")
+	cmd.Flags().IntVarP(&mtuflag.retries, "retries", "r", 2, "number of requests per size")
+	rootCmd.AddCommand(cmd)
+}
+
+func runmtu(cmd *cobra.Command, args []string) error {
+	host := args[0]
+	m := ping.NewIcmpMtu(host, mtuflag.timeout)
+	m.Privileged = mtuflag.privileged
+	m.MinMTU = mtuflag.min
+	m.MaxMTU = mtuflag.max
+	m.Retries = mtuflag.retries
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Printf("Path MTU %s:\n", host)
+	mtu, err := m.DiscoverContext(ctx, func(p *ping.MtuProbe) {
+		log.Printf("%v\n", p)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	fmt.Println()
+	fmt.Printf("\tpath mtu = %d\n", mtu)
+	return nil
+}
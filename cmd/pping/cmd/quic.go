@@ -51,5 +51,6 @@ func runquic(cmd *cobra.Command, args []string) error {
 	p.Insecure = quicflag.insecure
 	p.ALPN = quicflag.alpn
 	p.IP = ip
+	p.TOS = globalflag.tos
 	return RunPing(p)
 }
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wzv5/pping/pkg/ping"
+
+	"github.com/spf13/cobra"
+)
+
+type rankFlags struct {
+	mode     string
+	timeout  time.Duration
+	port     uint16
+	insecure bool
+	file     string
+	jobs     int
+	rate     float64
+	top      int
+	speed    bool
+	export   string
+}
+
+var rankflag rankFlags
+
+func addRankCommand() {
+	var cmd = &cobra.Command{
+		Use:   "rank <url|host> [ip|cidr]...",
+		Short: "rank candidate ips of a host",
+		Long:  "ping each candidate ip -c times with the real Host/SNI, then rank them by loss and latency",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runrank,
+	}
+
+	cmd.Flags().StringVarP(&rankflag.mode, "mode", "m", "http", "one of http, tls, tcp")
+	cmd.Flags().DurationVarP(&rankflag.timeout, "timeout", "w", time.Second*4, "timeout")
+	cmd.Flags().Uint16VarP(&rankflag.port, "port", "p", 443, "port for tls and tcp")
+	cmd.Flags().BoolVarP(&rankflag.insecure, "insecure", "k", false, "allow insecure server connections")
+	cmd.Flags().StringVarP(&rankflag.file, "file", "f", "", "read candidate ips or cidrs from file, one per line")
+	cmd.Flags().IntVarP(&rankflag.jobs, "jobs", "j", 16, "number of ips tested concurrently")
+	cmd.Flags().Float64Var(&rankflag.rate, "rate", 0, "maximum number of ips started per second, 0 for no limit")
+	cmd.Flags().IntVar(&rankflag.top, "top", 0, "only show the best N ips, 0 for all")
+	cmd.Flags().BoolVarP(&rankflag.speed, "speed", "s", false, "rank by download speed of the url instead of latency (http only)")
+	cmd.Flags().StringVarP(&rankflag.export, "export", "o", "", "write the ranked ips to file, one per line")
+	rootCmd.AddCommand(cmd)
+}
+
+type rankResult struct {
+	ip net.IP
+	s  statistics
+	// 成功请求的下载速度之和
+	speedsum float64
+}
+
+func (r *rankResult) loss() float64 {
+	if r.s.sent == 0 {
+		return 100
+	}
+	return 100 * float64(r.s.failed) / float64(r.s.sent)
+}
+
+// 平均下载速度，字节每秒
+func (r *rankResult) speed() float64 {
+	if r.s.ok == 0 {
+		return 0
+	}
+	return r.speedsum / float64(r.s.ok)
+}
+
+func runrank(cmd *cobra.Command, args []string) error {
+	target := args[0]
+	var newping func(ip net.IP) ping.IPing
+	switch rankflag.mode {
+	case "http":
+		if !strings.HasPrefix(target, "http") {
+			target = "http://" + target
+		}
+		newping = func(ip net.IP) ping.IPing {
+			p := ping.NewHttpPing("GET", target, rankflag.timeout)
+			p.Insecure = rankflag.insecure
+			p.IP = ip
+			p.TOS = globalflag.tos
+			return p
+		}
+	case "tls":
+		newping = func(ip net.IP) ping.IPing {
+			p := ping.NewTlsPing(target, rankflag.port, rankflag.timeout, rankflag.timeout)
+			p.Insecure = rankflag.insecure
+			p.IP = ip
+			p.TOS = globalflag.tos
+			return p
+		}
+	case "tcp":
+		newping = func(ip net.IP) ping.IPing {
+			p := ping.NewTcpPing(ip.String(), rankflag.port, rankflag.timeout)
+			p.TOS = globalflag.tos
+			return p
+		}
+	default:
+		return fmt.Errorf("unknown mode: %s", rankflag.mode)
+	}
+	if rankflag.speed && rankflag.mode != "http" {
+		return errors.New("--speed requires http mode")
+	}
+
+	candidates := args[1:]
+	if rankflag.file != "" {
+		lines, err := readLines(rankflag.file)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, lines...)
+	}
+	ips, err := parseCandidates(candidates)
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return errors.New("no candidate ip")
+	}
+	fmt.Printf("Rank %d ips for %s (%s):\n", len(ips), target, rankflag.mode)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	// 由探测协程直接保存，中断时 runSweep 不再输出结果，仍然可以对已完成的部分排序
+	collected := make([]*rankResult, len(ips))
+	runSweep(ctx, len(ips), rankflag.jobs, rankflag.rate, func(ctx context.Context, i int) *rankResult {
+		r := &rankResult{ip: ips[i]}
+		collected[i] = r
+		p := newping(ips[i])
+		for n := 0; n < globalflag.n; n++ {
+			if n > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(globalflag.i):
+				}
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			result := p.PingContext(ctx)
+			if ctx.Err() != nil {
+				break
+			}
+			r.s.append(result)
+			if hr, ok := result.(*ping.HttpPingResult); ok && hr.Err == nil {
+				r.speedsum += hr.Speed
+			}
+		}
+		return r
+	}, func(i int, r *rankResult) {})
+
+	var results []*rankResult
+	for _, r := range collected {
+		if r != nil && r.s.sent > 0 {
+			results = append(results, r)
+		}
+	}
+	sortRank(results, rankflag.speed)
+	if rankflag.top > 0 && len(results) > rankflag.top {
+		results = results[:rankflag.top]
+	}
+
+	fmt.Println()
+	if rankflag.speed {
+		fmt.Printf("%4s  %-39s  %6s  %8s  %8s  %8s  %12s\n", "#", "ip", "loss", "min", "avg", "max", "speed")
+	} else {
+		fmt.Printf("%4s  %-39s  %6s  %8s  %8s  %8s\n", "#", "ip", "loss", "min", "avg", "max")
+	}
+	ok := false
+	for i, r := range results {
+		line := fmt.Sprintf("%4d  %-39s  %5.1f%%", i+1, r.ip.String(), r.loss())
+		if r.s.ok > 0 {
+			ok = true
+			line += fmt.Sprintf("  %5d ms  %5.0f ms  %5d ms", r.s.min, r.s.avg(), r.s.max)
+			if rankflag.speed {
+				line += fmt.Sprintf("  %12s", ping.FormatSpeed(r.speed()))
+			}
+		}
+		fmt.Println(line)
+	}
+
+	if rankflag.export != "" {
+		var sb strings.Builder
+		for _, r := range results {
+			if r.s.ok > 0 {
+				sb.WriteString(r.ip.String())
+				sb.WriteString("\n")
+			}
+		}
+		if err := os.WriteFile(rankflag.export, []byte(sb.String()), 0644); err != nil {
+			return err
+		}
+	}
+	if !ok {
+		return ErrPing
+	}
+	return nil
+}
+
+// 按丢包率排序，其次按平均延迟或下载速度
+func sortRank(results []*rankResult, byspeed bool) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.loss() != b.loss() {
+			return a.loss() < b.loss()
+		}
+		if byspeed {
+			return a.speed() > b.speed()
+		}
+		return a.s.avg() < b.s.avg()
+	})
+}
+
+// 解析候选地址，可以是 IP 或 CIDR，去除重复的地址
+func parseCandidates(candidates []string) ([]net.IP, error) {
+	var ips []net.IP
+	seen := make(map[string]bool)
+	add := func(ip net.IP) {
+		if !seen[ip.String()] {
+			seen[ip.String()] = true
+			ips = append(ips, ip)
+		}
+	}
+	for _, s := range candidates {
+		if isCIDR(s) {
+			expanded, err := expandCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", s, err)
+			}
+			for _, ip := range expanded {
+				add(ip)
+			}
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip: %q", s)
+		}
+		add(ip)
+	}
+	return ips, nil
+}
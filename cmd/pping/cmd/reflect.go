@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/wzv5/pping/pkg/ping"
+
+	"github.com/spf13/cobra"
+)
+
+func addReflectCommand() {
+	var cmd = &cobra.Command{
+		Use:   "reflect [address]",
+		Short: "stamp session reflector",
+		Long:  "stamp (RFC 8762) session reflector, answers \"pping stamp\", listens on :862 by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  runreflect,
+	}
+
+	rootCmd.AddCommand(cmd)
+}
+
+func runreflect(cmd *cobra.Command, args []string) error {
+	addr := ":" + strconv.Itoa(ping.StampPort)
+	if len(args) == 1 {
+		addr = args[0]
+	}
+	r := ping.NewStampReflector(addr)
+	r.OnSession = func(addr net.Addr) {
+		log.Printf("new session from %s\n", addr)
+	}
+	if err := r.Listen(); err != nil {
+		return err
+	}
+	log.Printf("listening on %s\n", r.LocalAddr())
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	return r.Serve(ctx)
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -25,6 +27,8 @@ type globalFlags struct {
 	i    time.Duration
 	ipv4 bool
 	ipv6 bool
+	tos  int
+	dscp int
 }
 
 var globalflag globalFlags
@@ -40,8 +44,21 @@ func init() {
 	rootCmd.PersistentFlags().DurationVarP(&globalflag.i, "interval", "i", time.Second*1, "delay between each request")
 	rootCmd.PersistentFlags().BoolVarP(&globalflag.ipv4, "ipv4", "4", false, "use IPv4")
 	rootCmd.PersistentFlags().BoolVarP(&globalflag.ipv6, "ipv6", "6", false, "use IPv6")
+	rootCmd.PersistentFlags().IntVar(&globalflag.tos, "tos", 0, "IPv4 TOS / IPv6 traffic class (0-255)")
+	rootCmd.PersistentFlags().IntVar(&globalflag.dscp, "dscp", 0, "DSCP value (0-63), e.g. 46 for EF")
+	rootCmd.MarkFlagsMutuallyExclusive("tos", "dscp")
 
-	rootCmd.PersistentPreRun = func(*cobra.Command, []string) {
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if globalflag.tos < 0 || globalflag.tos > 255 {
+			return fmt.Errorf("invalid tos: %d", globalflag.tos)
+		}
+		if globalflag.dscp < 0 || globalflag.dscp > 63 {
+			return fmt.Errorf("invalid dscp: %d", globalflag.dscp)
+		}
+		if globalflag.dscp > 0 {
+			// DSCP 为 TOS 字段的高 6 位
+			globalflag.tos = globalflag.dscp << 2
+		}
 		if globalflag.ipv4 && !globalflag.ipv6 {
 			ping.LookupFunc = ping.LookupIPv4
 		} else if !globalflag.ipv4 && globalflag.ipv6 {
@@ -49,6 +66,7 @@ func init() {
 		} else {
 			ping.LookupFunc = ping.LookupIP
 		}
+		return nil
 	}
 
 	addTcpCommand()
@@ -57,6 +75,13 @@ func init() {
 	addIcmpCommand()
 	addDnsCommand()
 	addQuicCommand()
+	addUdpCommand()
+	addStampCommand()
+	addReflectCommand()
+	addRankCommand()
+	addTraceCommand()
+	addMtrCommand()
+	addMtuCommand()
 }
 
 func Execute() error {
@@ -69,14 +94,24 @@ func Execute() error {
 }
 
 func PingToChan(ctx context.Context, p ping.IPing) <-chan ping.IPingResult {
-	c := make(chan ping.IPingResult)
+	// 带缓冲，调用者提前退出时协程不会阻塞
+	c := make(chan ping.IPingResult, 1)
 	go func() {
 		c <- p.PingContext(ctx)
 	}()
 	return c
 }
 
-func RunPing(p ping.IPing) error {
+// 额外的统计信息，在默认的统计信息之后输出
+type summarizer interface {
+	append(ping.IPingResult)
+	print()
+}
+
+func RunPing(p ping.IPing, extra ...summarizer) error {
+	if c, ok := p.(io.Closer); ok {
+		defer c.Close()
+	}
 	if globalflag.n > 1 {
 		// 预热，由于某些资源需要初始化，首次运行会耗时较长
 		p.Ping()
@@ -92,6 +127,9 @@ func RunPing(p ping.IPing) error {
 		case result := <-PingToChan(ctx, p):
 			PrintResult(i, result)
 			s.append(result)
+			for _, e := range extra {
+				e.append(result)
+			}
 		case <-c:
 			goto end
 		}
@@ -119,6 +157,9 @@ end:
 	cancel()
 	if globalflag.n > 1 {
 		s.print()
+		for _, e := range extra {
+			e.print()
+		}
 	}
 	if s.sent == 0 || s.failed != 0 {
 		return ErrPing
@@ -129,6 +170,9 @@ end:
 type statistics struct {
 	max, min, total  int
 	sent, ok, failed int
+
+	last    int
+	sqtotal float64
 }
 
 func (s *statistics) append(result ping.IPingResult) {
@@ -140,7 +184,10 @@ func (s *statistics) append(result ping.IPingResult) {
 		s.failed++
 		return
 	}
-	t := result.Result()
+	s.add(result.Result())
+}
+
+func (s *statistics) add(t int) {
 	if s.ok == 0 {
 		s.min = t
 		s.max = t
@@ -152,9 +199,26 @@ func (s *statistics) append(result ping.IPingResult) {
 		}
 	}
 	s.total += t
+	s.sqtotal += float64(t) * float64(t)
+	s.last = t
 	s.ok++
 }
 
+func (s *statistics) avg() float64 {
+	if s.ok == 0 {
+		return 0
+	}
+	return float64(s.total) / float64(s.ok)
+}
+
+func (s *statistics) stddev() float64 {
+	if s.ok == 0 {
+		return 0
+	}
+	avg := s.avg()
+	return math.Sqrt(math.Max(s.sqtotal/float64(s.ok)-avg*avg, 0))
+}
+
 func (s *statistics) clear() {
 	s.max = 0
 	s.min = 0
@@ -162,6 +226,8 @@ func (s *statistics) clear() {
 	s.sent = 0
 	s.ok = 0
 	s.failed = 0
+	s.last = 0
+	s.sqtotal = 0
 }
 
 func (s *statistics) print() {
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wzv5/pping/pkg/ping"
+
+	"github.com/spf13/cobra"
+)
+
+type stampFlags struct {
+	timeout time.Duration
+	port    uint16
+}
+
+var stampflag stampFlags
+
+func addStampCommand() {
+	var cmd = &cobra.Command{
+		Use:   "stamp <host>",
+		Short: "stamp (twamp-light) ping",
+		Long:  "stamp (RFC 8762) ping, reports one-way delay, loss and reordering per direction, the remote end must run \"pping reflect\"",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runstamp,
+	}
+
+	cmd.Flags().DurationVarP(&stampflag.timeout, "timeout", "w", time.Second*4, "timeout")
+	cmd.Flags().Uint16VarP(&stampflag.port, "port", "p", ping.StampPort, "port")
+	rootCmd.AddCommand(cmd)
+}
+
+func runstamp(cmd *cobra.Command, args []string) error {
+	host := args[0]
+	fmt.Printf("Ping %s (%d):\n", host, stampflag.port)
+	p := ping.NewStampPing(host, stampflag.timeout)
+	p.Port = stampflag.port
+	p.TOS = globalflag.tos
+	return RunPing(p, &stampStatistics{p: p})
+}
+
+type stampStatistics struct {
+	p                *ping.StampPing
+	ok               int
+	forward, reverse time.Duration
+}
+
+func (s *stampStatistics) append(r ping.IPingResult) {
+	if r.Error() != nil {
+		return
+	}
+	result := r.(*ping.StampPingResult)
+	s.ok++
+	s.forward += result.Forward
+	s.reverse += result.Reverse
+}
+
+func (s *stampStatistics) print() {
+	stats := s.p.Statistics()
+	fmt.Printf("\tforward: loss = %d, out of order = %d", stats.ForwardLoss, stats.ForwardReordered)
+	if s.ok > 0 {
+		fmt.Printf(", avg = %.3f ms", float64(s.forward)/float64(s.ok)/float64(time.Millisecond))
+	}
+	fmt.Println()
+	fmt.Printf("\treverse: loss = %d, out of order = %d", stats.ReverseLoss, stats.ReverseReordered)
+	if s.ok > 0 {
+		fmt.Printf(", avg = %.3f ms", float64(s.reverse)/float64(s.ok)/float64(time.Millisecond))
+	}
+	fmt.Println()
+}
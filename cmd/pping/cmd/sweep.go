@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 单次扫描最多的地址数
+const maxSweepHosts = 65536
+
+func isCIDR(s string) bool {
+	return strings.Contains(s, "/")
+}
+
+// 展开 CIDR 中的所有地址，IPv4 前缀小于 31 时不包括网络地址和广播地址
+func expandCIDR(s string) ([]net.IP, error) {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, errors.New("address range too large")
+	}
+	if ip.To4() != nil {
+		ip = ip.To4()
+	}
+	start := new(big.Int).SetBytes(ip.Mask(ipnet.Mask))
+	n := 1 << (bits - ones)
+	skipfirst, skiplast := false, false
+	if bits == 32 && ones < 31 {
+		skipfirst, skiplast = true, true
+	}
+	ips := make([]net.IP, 0, n)
+	for i := 0; i < n && i < maxSweepHosts; i++ {
+		if (i == 0 && skipfirst) || (i == n-1 && skiplast) {
+			continue
+		}
+		v := new(big.Int).Add(start, big.NewInt(int64(i))).Bytes()
+		b := make(net.IP, len(ip))
+		copy(b[len(b)-len(v):], v)
+		ips = append(ips, b)
+	}
+	return ips, nil
+}
+
+// 并发执行 n 个探测，最多同时执行 jobs 个，每秒最多开始 rate 个（为 0 时不限制）。
+// 按序号顺序对结果调用 f，ctx 结束后不再开始新的探测，也不再输出
+func runSweep[T any](ctx context.Context, n, jobs int, rate float64, probe func(ctx context.Context, i int) T, f func(i int, r T)) {
+	results := make([]T, n)
+	done := make(chan int)
+	go func() {
+		sem := make(chan struct{}, max(jobs, 1))
+		var tick <-chan time.Time
+		if rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			if i > 0 && tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+				}
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = probe(ctx, i)
+				<-sem
+				done <- i
+			}(i)
+		}
+		wg.Wait()
+		close(done)
+	}()
+
+	next := 0
+	finished := make([]bool, n)
+	for i := range done {
+		finished[i] = true
+		for ; next < n && finished[next]; next++ {
+			if ctx.Err() == nil {
+				f(next, results[next])
+			}
+		}
+	}
+}
@@ -1,8 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/wzv5/pping/pkg/ping"
@@ -12,6 +19,12 @@ import (
 
 type tcpFlags struct {
 	timeout time.Duration
+	data    string
+	hexdata string
+	wait    bool
+	expect  string
+	jobs    int
+	rate    float64
 }
 
 var tcpflag tcpFlags
@@ -20,22 +33,157 @@ func addTcpCommand() {
 	var cmd = &cobra.Command{
 		Use:   "tcp <host> <port>",
 		Short: "tcp ping",
-		Long:  "tcp ping",
+		Long:  "tcp ping, or probe each port once when given a list such as 22,80,443,8000-8010, or each host once when given a CIDR such as 10.0.0.0/24",
 		Args:  cobra.ExactArgs(2),
 		RunE:  runtcp,
 	}
 
 	cmd.Flags().DurationVarP(&tcpflag.timeout, "timeout", "w", time.Second*4, "timeout")
+	cmd.Flags().StringVarP(&tcpflag.data, "data", "d", "", "send the string after connecting")
+	cmd.Flags().StringVar(&tcpflag.hexdata, "hex", "", "send the hex data after connecting")
+	cmd.Flags().BoolVarP(&tcpflag.wait, "wait", "r", false, "wait for the first bytes from the server")
+	cmd.Flags().StringVarP(&tcpflag.expect, "expect", "e", "", "wait for a response matching the regexp")
+	cmd.Flags().IntVarP(&tcpflag.jobs, "jobs", "j", 16, "number of ports or hosts probed concurrently")
+	cmd.Flags().Float64Var(&tcpflag.rate, "rate", 0, "maximum number of probes started per second when probing multiple ports or hosts, 0 for no limit")
+	cmd.MarkFlagsMutuallyExclusive("data", "hex")
 	rootCmd.AddCommand(cmd)
 }
 
 func runtcp(cmd *cobra.Command, args []string) error {
 	host := args[0]
-	port, err := strconv.ParseUint(args[1], 10, 16)
+	ports, err := parsePorts(args[1])
 	if err != nil {
 		return err
 	}
+	if len(ports) > 1 || isCIDR(host) {
+		if tcpflag.data != "" || tcpflag.hexdata != "" || tcpflag.wait || tcpflag.expect != "" {
+			return errors.New("--data, --hex, --wait and --expect require a single port")
+		}
+		if isCIDR(host) {
+			if len(ports) > 1 {
+				return errors.New("a CIDR range requires a single port")
+			}
+			return runtcpcidr(host, ports[0])
+		}
+		return runtcpscan(host, ports)
+	}
+	port := ports[0]
 	fmt.Printf("Ping %s (%d):\n", host, port)
-	p := ping.NewTcpPing(host, uint16(port), tcpflag.timeout)
+	p := ping.NewTcpPing(host, port, tcpflag.timeout)
+	p.TOS = globalflag.tos
+	if tcpflag.data != "" {
+		p.Send = []byte(tcpflag.data)
+	} else if tcpflag.hexdata != "" {
+		p.Send, err = hex.DecodeString(tcpflag.hexdata)
+		if err != nil {
+			return fmt.Errorf("invalid hex data: %w", err)
+		}
+	}
+	p.WaitResponse = tcpflag.wait
+	if tcpflag.expect != "" {
+		p.Expect, err = regexp.Compile(tcpflag.expect)
+		if err != nil {
+			return err
+		}
+	}
 	return RunPing(p)
 }
+
+// 解析以逗号分隔的端口及端口范围，如 22,80,8000-8010，去除重复的端口
+func parsePorts(s string) ([]uint16, error) {
+	var ports []uint16
+	seen := make(map[uint16]bool)
+	for _, part := range strings.Split(s, ",") {
+		from, to, isrange := strings.Cut(strings.TrimSpace(part), "-")
+		lo, err := strconv.ParseUint(from, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %q", part)
+		}
+		hi := lo
+		if isrange {
+			hi, err = strconv.ParseUint(to, 10, 16)
+			if err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid port range: %q", part)
+			}
+		}
+		for p := lo; p <= hi; p++ {
+			if !seen[uint16(p)] {
+				seen[uint16(p)] = true
+				ports = append(ports, uint16(p))
+			}
+		}
+	}
+	return ports, nil
+}
+
+// 并发探测多个端口，按端口顺序输出状态
+func runtcpscan(host string, ports []uint16) error {
+	ip, err := ping.LookupFunc(host)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scan %s (%s), %d ports:\n", host, ip.String(), len(ports))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	counts := make(map[ping.PortState]int)
+	runSweep(ctx, len(ports), tcpflag.jobs, tcpflag.rate, func(ctx context.Context, i int) ping.IPingResult {
+		p := ping.NewTcpPing(ip.String(), ports[i], tcpflag.timeout)
+		p.TOS = globalflag.tos
+		return p.PingContext(ctx)
+	}, func(i int, result ping.IPingResult) {
+		r := result.(*ping.TcpPingResult)
+		state := r.State()
+		counts[state]++
+		switch state {
+		case ping.PortOpen:
+			fmt.Printf("%5d/tcp  %-8s  %d ms\n", ports[i], state, r.Time)
+		case ping.PortError:
+			fmt.Printf("%5d/tcp  %-8s  %v\n", ports[i], state, r.Err)
+		default:
+			fmt.Printf("%5d/tcp  %s\n", ports[i], state)
+		}
+	})
+	fmt.Println()
+	line := fmt.Sprintf("\topen = %d, closed = %d, filtered = %d", counts[ping.PortOpen], counts[ping.PortClosed], counts[ping.PortFiltered])
+	if counts[ping.PortError] > 0 {
+		line += fmt.Sprintf(", error = %d", counts[ping.PortError])
+	}
+	fmt.Println(line)
+	if counts[ping.PortOpen] == 0 {
+		return ErrPing
+	}
+	return nil
+}
+
+// 探测 CIDR 中每个地址的同一端口，输出端口开放的地址
+func runtcpcidr(cidr string, port uint16) error {
+	ips, err := expandCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sweep %s (%d), %d hosts:\n", cidr, port, len(ips))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	alive := 0
+	runSweep(ctx, len(ips), tcpflag.jobs, tcpflag.rate, func(ctx context.Context, i int) ping.IPingResult {
+		p := ping.NewTcpPing(ips[i].String(), port, tcpflag.timeout)
+		p.TOS = globalflag.tos
+		return p.PingContext(ctx)
+	}, func(i int, r ping.IPingResult) {
+		// 回复了 RST 的主机同样在线
+		if state := r.(*ping.TcpPingResult).State(); state == ping.PortOpen || state == ping.PortClosed {
+			alive++
+			fmt.Printf("%-15s  %-6s  %d ms\n", ips[i].String(), state, r.(*ping.TcpPingResult).Time)
+		}
+	})
+	fmt.Println()
+	fmt.Printf("\talive = %d, total = %d\n", alive, len(ips))
+	if alive == 0 {
+		return ErrPing
+	}
+	return nil
+}
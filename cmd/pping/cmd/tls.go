@@ -68,5 +68,6 @@ func runtls(cmd *cobra.Command, args []string) error {
 	p.TlsVersion = tlsflag.tlsver
 	p.Insecure = tlsflag.insecure
 	p.IP = ip
+	p.TOS = globalflag.tos
 	return RunPing(p)
 }
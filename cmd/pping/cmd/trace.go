@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/wzv5/pping/pkg/ping"
+
+	"github.com/spf13/cobra"
+)
+
+type traceFlags struct {
+	privileged bool
+	timeout    time.Duration
+	maxhops    int
+	probes     int
+	size       int
+	tcp        bool
+	port       uint16
+}
+
+var traceflag traceFlags
+
+func addTraceCommand() {
+	var cmd = &cobra.Command{
+		Use:   "trace <host>",
+		Short: "icmp traceroute",
+		Long:  "icmp traceroute, or tcp syn traceroute with --tcp",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runtrace,
+	}
+
+	cmd.Flags().DurationVarP(&traceflag.timeout, "timeout", "w", time.Second*2, "timeout")
+	cmd.Flags().BoolVarP(&traceflag.privileged, "privileged", "p", false, "privileged")
+	cmd.Flags().IntVarP(&traceflag.maxhops, "max-hops", "m", 30, "maximum number of hops")
+	cmd.Flags().IntVarP(&traceflag.probes, "queries", "q", 3, "number of probes per hop")
+	cmd.Flags().IntVarP(&traceflag.size, "size", "s", 0, "send buffer size")
+	cmd.Flags().BoolVarP(&traceflag.tcp, "tcp", "T", false, "use tcp syn instead of icmp echo")
+	cmd.Flags().Uint16Var(&traceflag.port, "port", 443, "destination port for tcp syn")
+	rootCmd.AddCommand(cmd)
+}
+
+func runtrace(cmd *cobra.Command, args []string) error {
+	host := args[0]
+	var t tracer
+	maxhops := traceflag.maxhops
+	if traceflag.tcp {
+		tt := ping.NewTcpTrace(host, traceflag.port, traceflag.timeout)
+		tt.Privileged = traceflag.privileged
+		tt.MaxHops = maxhops
+		if traceflag.probes > 0 {
+			tt.Probes = traceflag.probes
+		}
+		t = tt
+	} else {
+		it := ping.NewIcmpTrace(host, traceflag.timeout)
+		it.Privileged = traceflag.privileged
+		it.MaxHops = maxhops
+		if traceflag.probes > 0 {
+			it.Probes = traceflag.probes
+		}
+		if traceflag.size > 0 {
+			it.Size = traceflag.size
+		}
+		t = it
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Printf("Trace %s, %d hops max:\n", host, maxhops)
+	reached := false
+	err := t.TraceContext(ctx, func(hop *ping.TraceHop) {
+		fmt.Println(hop)
+		reached = hop.Reached()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	if !reached {
+		return ErrPing
+	}
+	return nil
+}
+
+type tracer interface {
+	TraceContext(context.Context, func(*ping.TraceHop)) error
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/wzv5/pping/pkg/ping"
+
+	"github.com/spf13/cobra"
+)
+
+type udpFlags struct {
+	timeout time.Duration
+	data    string
+	hexdata string
+	file    string
+	expect  string
+}
+
+var udpflag udpFlags
+
+func addUdpCommand() {
+	var cmd = &cobra.Command{
+		Use:   "udp <host> <port>",
+		Short: "udp ping",
+		Long:  "udp ping, send a payload and wait for any reply, or a reply matching --expect",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runudp,
+	}
+
+	cmd.Flags().DurationVarP(&udpflag.timeout, "timeout", "w", time.Second*4, "timeout")
+	cmd.Flags().StringVarP(&udpflag.data, "data", "d", "", "payload as string")
+	cmd.Flags().StringVar(&udpflag.hexdata, "hex", "", "payload as hex")
+	cmd.Flags().StringVarP(&udpflag.file, "file", "f", "", "read payload from file")
+	cmd.Flags().StringVarP(&udpflag.expect, "expect", "e", "", "regexp the reply must match")
+	cmd.MarkFlagsMutuallyExclusive("data", "hex", "file")
+	rootCmd.AddCommand(cmd)
+}
+
+func runudp(cmd *cobra.Command, args []string) error {
+	host := args[0]
+	port, err := strconv.ParseUint(args[1], 10, 16)
+	if err != nil {
+		return err
+	}
+	p := ping.NewUdpPing(host, uint16(port), udpflag.timeout)
+	p.TOS = globalflag.tos
+	switch {
+	case udpflag.data != "":
+		p.Payload = []byte(udpflag.data)
+	case udpflag.hexdata != "":
+		p.Payload, err = hex.DecodeString(udpflag.hexdata)
+		if err != nil {
+			return fmt.Errorf("invalid hex payload: %w", err)
+		}
+	case udpflag.file != "":
+		p.Payload, err = os.ReadFile(udpflag.file)
+		if err != nil {
+			return err
+		}
+	}
+	if udpflag.expect != "" {
+		p.Expect, err = regexp.Compile(udpflag.expect)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Ping %s (%d):\n", host, port)
+	return RunPing(p)
+}
@@ -0,0 +1,202 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// HTTP/3 失败后暂停尝试的时间
+const altSvcBrokenTime = 5 * time.Minute
+
+// 已知的 HTTP/3 端点。连接的地址始终为 HttpPing 的 IP，因此只记录端口
+type altSvc struct {
+	port    string
+	expires time.Time
+	// 来源，Alt-Svc 或 HTTPS RR
+	source string
+}
+
+// 自动升级的状态，由 HttpPing.mu 保护
+type autoState struct {
+	alt altSvc
+	// 已查询过 HTTPS 记录
+	httpsrr bool
+	// HTTP/3 失败后，在此之前不再尝试
+	broken time.Time
+	// 上次请求使用了 HTTP/3
+	lasth3 bool
+}
+
+// 有已知的 HTTP/3 端点时使用 HTTP/3，失败时在同一次 ping 中回退到 TCP；
+// 否则使用 TCP，并从响应头中获取 HTTP/3 端点供之后的请求使用
+func (this *HttpPing) autoPing(ctx context.Context, u *url.URL, ip net.IP) *HttpPingResult {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	st := &this.auto
+	now := time.Now()
+	var notes []string
+	if this.HttpsResolver != nil && !st.httpsrr {
+		st.httpsrr = true
+		alt, err := this.lookupHttps(ctx, u)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("HTTPS RR lookup failed: %v", err))
+		} else if alt.port != "" && st.alt.port == "" {
+			st.alt = alt
+		}
+	}
+	if st.alt.port != "" && now.After(st.alt.expires) {
+		st.alt = altSvc{}
+		if st.lasth3 {
+			notes = append(notes, "alt-svc expired, back to TCP")
+		}
+	}
+
+	if st.alt.port != "" && now.After(st.broken) {
+		result, header := this.do(ctx, u, ip, true, st.alt.port)
+		if result.Err == nil {
+			if !st.lasth3 {
+				notes = append(notes, fmt.Sprintf("upgraded to HTTP/3 via %s (port %s)", st.alt.source, st.alt.port))
+			}
+			st.lasth3 = true
+			if note := st.update(header, now); note != "" {
+				notes = append(notes, note)
+			}
+			result.Note = strings.Join(notes, "; ")
+			return result
+		}
+		if ctx.Err() != nil {
+			return result
+		}
+		notes = append(notes, fmt.Sprintf("HTTP/3 failed (%v), fell back to TCP", result.Err))
+		st.alt = altSvc{}
+		st.broken = now.Add(altSvcBrokenTime)
+	}
+
+	result, header := this.do(ctx, u, ip, false, "")
+	st.lasth3 = false
+	if result.Err == nil {
+		if note := st.update(header, now); note != "" {
+			notes = append(notes, note)
+		}
+		result.Note = strings.Join(notes, "; ")
+	}
+	return result
+}
+
+// 根据 Alt-Svc 响应头更新 HTTP/3 端点，返回变化的说明
+func (this *autoState) update(header http.Header, now time.Time) string {
+	v := strings.Join(header.Values("Alt-Svc"), ",")
+	if v == "" {
+		return ""
+	}
+	port, maxAge, clear := parseAltSvc(v)
+	if clear {
+		if this.alt.port == "" {
+			return ""
+		}
+		this.alt = altSvc{}
+		return "alt-svc cleared"
+	}
+	if port == "" {
+		return ""
+	}
+	old := this.alt
+	this.alt = altSvc{port: port, expires: now.Add(maxAge), source: "Alt-Svc"}
+	if old.port == port {
+		return ""
+	}
+	if now.Before(this.broken) {
+		return fmt.Sprintf("alt-svc advertises h3 on port %s, retry after %s", port, this.broken.Format("15:04:05"))
+	}
+	return fmt.Sprintf("alt-svc advertises h3 on port %s", port)
+}
+
+// 解析 Alt-Svc 响应头，返回第一个 h3 端点的端口及有效期，或是否为 clear
+func parseAltSvc(v string) (port string, maxAge time.Duration, clear bool) {
+	if strings.TrimSpace(v) == "clear" {
+		return "", 0, true
+	}
+	for _, entry := range strings.Split(v, ",") {
+		params := strings.Split(entry, ";")
+		proto, authority, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !ok || proto != "h3" {
+			continue
+		}
+		_, p, err := net.SplitHostPort(strings.Trim(authority, `"`))
+		if err != nil || p == "" {
+			continue
+		}
+		// 默认有效期为 24 小时
+		maxAge = 24 * time.Hour
+		for _, param := range params[1:] {
+			k, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if k != "ma" {
+				continue
+			}
+			if n, err := strconv.ParseUint(strings.Trim(val, `"`), 10, 32); err == nil {
+				maxAge = time.Duration(n) * time.Second
+			}
+		}
+		return p, maxAge, false
+	}
+	return "", 0, false
+}
+
+// 查询 HTTPS 记录，返回优先级最高的支持 h3 的端点，没有时 port 为空
+func (this *HttpPing) lookupHttps(ctx context.Context, u *url.URL) (altSvc, error) {
+	resolver := this.HttpsResolver
+	ip := cloneIP(resolver.ip)
+	if ip == nil {
+		var err error
+		ip, err = LookupFunc(resolver.host)
+		if err != nil {
+			return altSvc{}, err
+		}
+	}
+	name := u.Hostname()
+	defport := "443"
+	if p := u.Port(); p != "" && p != defport {
+		name = fmt.Sprintf("_%s._https.%s", p, name)
+		defport = p
+	}
+	r := resolver.query(ctx, ip, name, "HTTPS")
+	if r.Err != nil {
+		return altSvc{}, r.Err
+	}
+	var best *dns.HTTPS
+	var alt altSvc
+	for _, rr := range r.Answer {
+		h, ok := rr.(*dns.HTTPS)
+		// 不支持别名模式
+		if !ok || h.Priority == 0 || (best != nil && h.Priority >= best.Priority) {
+			continue
+		}
+		h3, port := false, defport
+		for _, kv := range h.Value {
+			switch kv := kv.(type) {
+			case *dns.SVCBAlpn:
+				h3 = slices.Contains(kv.Alpn, "h3")
+			case *dns.SVCBPort:
+				port = strconv.Itoa(int(kv.Port))
+			}
+		}
+		if h3 {
+			best = h
+			alt = altSvc{
+				port:    port,
+				expires: time.Now().Add(time.Duration(h.Hdr.Ttl) * time.Second),
+				source:  "HTTPS RR",
+			}
+		}
+	}
+	return alt, nil
+}
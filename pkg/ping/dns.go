@@ -2,21 +2,48 @@ package ping
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
 )
 
 type DnsPingResult struct {
 	Time int
 	Err  error
 	IP   net.IP
+	Net  string
+
+	// 仅 Net 为 quic 时有效
+	HandshakeTime int
+	QueryTime     int
+
+	Rcode             int
+	Answer            []dns.RR
+	MinTTL            uint32
+	Truncated         bool
+	AuthenticatedData bool
+	Size              int
+
+	// 服务器返回的 NSID，未请求或未返回时为空
+	NSID string
+
+	Authoritative bool
+
+	// 仅设置了 RandomPrefix 或 Names 时有效，Time 为未缓存域名 Name 的查询时间
+	Name       string
+	CachedTime int
 }
 
 func (this *DnsPingResult) Result() int {
@@ -30,9 +57,45 @@ func (this *DnsPingResult) Error() error {
 func (this *DnsPingResult) String() string {
 	if this.Err != nil {
 		return fmt.Sprintf("%s", this.Err)
-	} else {
-		return fmt.Sprintf("%s: time=%d ms", this.IP.String(), this.Time)
 	}
+	s := fmt.Sprintf("%s: rcode=%s, answers=[%s]", this.IP.String(), dns.RcodeToString[this.Rcode], answerString(this.Answer))
+	if len(this.Answer) > 0 {
+		s += fmt.Sprintf(", ttl=%d", this.MinTTL)
+	}
+	s += fmt.Sprintf(", size=%d", this.Size)
+	var flags []string
+	if this.Truncated {
+		flags = append(flags, "tc")
+	}
+	if this.AuthenticatedData {
+		flags = append(flags, "ad")
+	}
+	if this.Authoritative {
+		flags = append(flags, "aa")
+	}
+	if len(flags) > 0 {
+		s += fmt.Sprintf(", flags=%s", strings.Join(flags, " "))
+	}
+	if this.NSID != "" {
+		s += fmt.Sprintf(", nsid=%s", this.NSID)
+	}
+	if this.Net == "quic" {
+		s += fmt.Sprintf(", handshake=%d ms, query=%d ms", this.HandshakeTime, this.QueryTime)
+	}
+	if this.Name != "" {
+		return s + fmt.Sprintf(", cached=%d ms, uncached=%d ms", this.CachedTime, this.Time)
+	}
+	return s + fmt.Sprintf(", time=%d ms", this.Time)
+}
+
+// 应答的紧凑形式，每条记录为类型及数据部分，以逗号分隔
+func answerString(rrs []dns.RR) string {
+	list := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		rdata := strings.TrimPrefix(rr.String(), rr.Header().String())
+		list = append(list, dns.TypeToString[rr.Header().Rrtype]+" "+rdata)
+	}
+	return strings.Join(list, ",")
 }
 
 type DnsPing struct {
@@ -40,7 +103,7 @@ type DnsPing struct {
 	Port    uint16
 	Timeout time.Duration
 
-	// udp, tcp, tcp-tls，默认 udp
+	// udp, tcp, tcp-tls, quic，默认 udp
 	Net string
 
 	// A, AAAA, NS, ...，默认 NS
@@ -49,10 +112,48 @@ type DnsPing struct {
 	// 查询域名，默认 .
 	Domain string
 
-	// Net 为 tcp-tls 时，是否跳过证书验证
+	// Net 为 tcp-tls 或 quic 时，是否跳过证书验证
 	Insecure bool
 
-	ip net.IP
+	// 不设置 RD 位，用于查询权威服务器
+	NoRecursion bool
+
+	// IP 头部的 TOS 或 Traffic Class，为 0 时不设置
+	TOS int
+
+	// 期望的响应码，如 NOERROR，为空时不检查
+	ExpectRcode string
+
+	// 期望的应答 IP，与 A 或 AAAA 记录比较，为空时不检查
+	ExpectIP net.IP
+
+	// 期望的应答，与任一记录的数据部分匹配，为空时不检查
+	ExpectAnswer *regexp.Regexp
+
+	// 以下为 EDNS0 选项，设置任意一项时添加 OPT 记录
+
+	// 设置 DO 位
+	DNSSEC bool
+	// EDNS Client Subnet，如 1.2.3.0/24
+	ClientSubnet string
+	// 请求 NSID
+	NSID bool
+	// UDP 缓冲区大小，默认 1232
+	UDPSize uint16
+	// 发送 DNS Cookie
+	Cookie bool
+
+	// 以下用于测试未命中缓存的查询，设置任意一项时，每次 ping 会先查询一个未缓存的域名，
+	// 再查询 Domain 作为对照
+
+	// 在域名前添加随机标签
+	RandomPrefix bool
+	// 依次循环查询的域名列表，为空时使用 Domain
+	Names []string
+
+	ip     net.IP
+	cookie string
+	next   int
 }
 
 func (this *DnsPing) SetHost(host string) {
@@ -74,39 +175,353 @@ func (this *DnsPing) PingContext(ctx context.Context) IPingResult {
 		var err error
 		ip, err = LookupFunc(this.host)
 		if err != nil {
-			return &DnsPingResult{0, err, nil}
+			return this.errorResult(err)
 		}
 	}
 
+	if !this.RandomPrefix && len(this.Names) == 0 {
+		return this.query(ctx, ip, this.Domain, this.Type)
+	}
+
+	// 先查询未缓存的域名，再查询 Domain 作为缓存命中的对照
+	var name string
+	if len(this.Names) > 0 {
+		name = this.Names[this.next%len(this.Names)]
+		this.next++
+	} else {
+		name = this.Domain
+	}
+	if this.RandomPrefix {
+		b := make([]byte, 6)
+		rand.Read(b)
+		name = hex.EncodeToString(b) + "." + strings.TrimPrefix(dns.Fqdn(name), ".")
+	}
+	result := this.query(ctx, ip, name, this.Type)
+	if result.Err != nil {
+		return result
+	}
+	cached := this.query(ctx, ip, this.Domain, this.Type)
+	if cached.Err != nil {
+		return cached
+	}
+	result.Name = name
+	result.CachedTime = cached.Time
+	return result
+}
+
+func (this *DnsPing) query(ctx context.Context, ip net.IP, name, typ string) *DnsPingResult {
 	msg := &dns.Msg{}
-	qtype, ok := dns.StringToType[this.Type]
+	qtype, ok := dns.StringToType[typ]
 	if !ok {
-		return &DnsPingResult{0, errors.New("unknown type"), nil}
+		return this.errorResult(errors.New("unknown type"))
+	}
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.MsgHdr.RecursionDesired = !this.NoRecursion
+	if err := this.setEdns0(msg); err != nil {
+		return this.errorResult(err)
+	}
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(this.Port)))
+
+	var resp []byte
+	var handshake time.Duration
+	var err error
+	t0 := time.Now()
+	if this.Net == "quic" {
+		resp, handshake, err = this.exchangeQuic(ctx, msg, addr)
+	} else {
+		resp, err = this.exchange(ctx, msg, addr)
+	}
+	if err != nil {
+		return this.errorResult(err)
+	}
+	t1 := time.Now()
+
+	r := &dns.Msg{}
+	if err := r.Unpack(resp); err != nil {
+		return this.errorResult(err)
+	}
+	if !r.Response || r.Opcode != dns.OpcodeQuery {
+		return this.errorResult(errors.New("response error"))
+	}
+
+	result := &DnsPingResult{
+		Time:              int(t1.Sub(t0).Milliseconds()),
+		IP:                ip,
+		Net:               this.Net,
+		Rcode:             r.Rcode,
+		Answer:            r.Answer,
+		Truncated:         r.Truncated,
+		AuthenticatedData: r.AuthenticatedData,
+		Authoritative:     r.Authoritative,
+		Size:              len(resp),
+	}
+	if this.Net == "quic" {
+		result.HandshakeTime = int(handshake.Milliseconds())
+		result.QueryTime = int(t1.Sub(t0.Add(handshake)).Milliseconds())
+	}
+	for i, rr := range r.Answer {
+		if i == 0 || rr.Header().Ttl < result.MinTTL {
+			result.MinTTL = rr.Header().Ttl
+		}
 	}
-	if !strings.HasSuffix(this.Domain, ".") {
-		this.Domain += "."
+	if opt := r.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			switch o := o.(type) {
+			case *dns.EDNS0_NSID:
+				result.NSID = decodeNSID(o.Nsid)
+			case *dns.EDNS0_COOKIE:
+				// 保存服务器 cookie，下次查询时带上
+				if this.Cookie && len(o.Cookie) > 16 && strings.HasPrefix(o.Cookie, this.cookie[:16]) {
+					this.cookie = o.Cookie
+				}
+			}
+		}
 	}
-	msg.SetQuestion(this.Domain, qtype)
-	msg.MsgHdr.RecursionDesired = true
+	result.Err = this.check(result)
+	return result
+}
 
+func (this *DnsPing) setEdns0(msg *dns.Msg) error {
+	if !this.DNSSEC && this.ClientSubnet == "" && !this.NSID && this.UDPSize == 0 && !this.Cookie {
+		return nil
+	}
+	size := this.UDPSize
+	if size == 0 {
+		size = 1232
+	}
+	msg.SetEdns0(size, this.DNSSEC)
+	opt := msg.IsEdns0()
+	if this.ClientSubnet != "" {
+		ecs, err := parseClientSubnet(this.ClientSubnet)
+		if err != nil {
+			return err
+		}
+		opt.Option = append(opt.Option, ecs)
+	}
+	if this.NSID {
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	}
+	if this.Cookie {
+		if this.cookie == "" {
+			b := make([]byte, 8)
+			rand.Read(b)
+			this.cookie = hex.EncodeToString(b)
+		}
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: this.cookie})
+	}
+	return nil
+}
+
+func parseClientSubnet(s string) (*dns.EDNS0_SUBNET, error) {
+	var ip net.IP
+	// 未指定前缀时使用完整长度，/0 表示不使用客户端子网
+	bits := -1
+	if strings.Contains(s, "/") {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		ip = ipnet.IP
+		bits, _ = ipnet.Mask.Size()
+	} else {
+		ip = net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.New("parse client subnet failed")
+		}
+	}
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET}
+	if isIPv4(ip) {
+		ecs.Family = 1
+		ecs.Address = ip.To4()
+		if bits < 0 {
+			bits = 32
+		}
+	} else {
+		ecs.Family = 2
+		ecs.Address = ip
+		if bits < 0 {
+			bits = 128
+		}
+	}
+	ecs.SourceNetmask = uint8(bits)
+	return ecs, nil
+}
+
+// NSID 通常是可读字符串，否则保留十六进制
+func decodeNSID(nsid string) string {
+	b, err := hex.DecodeString(nsid)
+	if err != nil {
+		return nsid
+	}
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return nsid
+		}
+	}
+	return string(b)
+}
+
+func (this *DnsPing) check(r *DnsPingResult) error {
+	if this.ExpectRcode != "" {
+		rcode, ok := dns.StringToRcode[strings.ToUpper(this.ExpectRcode)]
+		if !ok {
+			return errors.New("unknown rcode")
+		}
+		if r.Rcode != rcode {
+			return fmt.Errorf("%s: unexpected rcode %s", r.IP.String(), dns.RcodeToString[r.Rcode])
+		}
+	}
+	if this.ExpectIP != nil || this.ExpectAnswer != nil {
+		for _, rr := range r.Answer {
+			ok := true
+			if this.ExpectIP != nil {
+				switch rr := rr.(type) {
+				case *dns.A:
+					ok = rr.A.Equal(this.ExpectIP)
+				case *dns.AAAA:
+					ok = rr.AAAA.Equal(this.ExpectIP)
+				default:
+					ok = false
+				}
+			}
+			if ok && this.ExpectAnswer != nil {
+				ok = this.ExpectAnswer.MatchString(strings.TrimPrefix(rr.String(), rr.Header().String()))
+			}
+			if ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: no matching answer", r.IP.String())
+	}
+	return nil
+}
+
+func (this *DnsPing) exchange(ctx context.Context, msg *dns.Msg, addr string) ([]byte, error) {
 	client := &dns.Client{}
 	client.Net = this.Net
 	client.Timeout = this.Timeout
+	client.Dialer = &net.Dialer{
+		Timeout: this.Timeout,
+		Control: tosControl(this.TOS),
+	}
 	client.TLSConfig = &tls.Config{
 		ServerName:         this.host,
 		InsecureSkipVerify: this.Insecure,
 	}
 
+	conn, err := client.DialContext(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if opt := msg.IsEdns0(); opt != nil {
+		conn.UDPSize = opt.UDPSize()
+	}
+	deadline := time.Now().Add(this.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if err := conn.WriteMsg(msg); err != nil {
+		return nil, err
+	}
+	// 不直接使用 Exchange，以便获取原始响应的大小
+	for {
+		var hdr dns.Header
+		resp, err := conn.ReadMsgHeader(&hdr)
+		if err != nil {
+			return nil, err
+		}
+		// 忽略 ID 不一致的响应，可能是之前超时的查询
+		if hdr.Id == msg.Id {
+			return resp, nil
+		}
+	}
+}
+
+// DNS over QUIC，https://www.rfc-editor.org/rfc/rfc9250
+
+func (this *DnsPing) exchangeQuic(ctx context.Context, msg *dns.Msg, addr string) ([]byte, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, this.Timeout)
+	defer cancel()
+
+	// DoQ 要求消息 ID 为 0
+	msg.Id = 0
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	tlsconf := &tls.Config{
+		ServerName:         this.host,
+		InsecureSkipVerify: this.Insecure,
+		NextProtos:         []string{"doq"},
+	}
+	quicconf := &quic.Config{
+		HandshakeIdleTimeout: this.Timeout,
+	}
+
+	var conn quic.Connection
 	t0 := time.Now()
-	r, _, err := client.ExchangeContext(ctx, msg, net.JoinHostPort(ip.String(), strconv.Itoa(int(this.Port))))
+	if this.TOS > 0 {
+		udpconn, udpaddr, lerr := listenUDPTOS(addr, this.TOS)
+		if lerr != nil {
+			return nil, 0, lerr
+		}
+		defer udpconn.Close()
+		conn, err = quic.Dial(ctx, udpconn, udpaddr, tlsconf, quicconf)
+	} else {
+		conn, err = quic.DialAddr(ctx, addr, tlsconf, quicconf)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	// DOQ_NO_ERROR
+	defer conn.CloseWithError(0, "")
+	handshake := time.Since(t0)
+
+	stream, err := conn.OpenStreamSync(ctx)
 	if err != nil {
-		return &DnsPingResult{0, err, nil}
+		return nil, 0, err
 	}
-	if r == nil || !r.Response || r.Opcode != dns.OpcodeQuery {
-		return &DnsPingResult{0, errors.New("response error"), nil}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
 	}
+	buf := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(buf, uint16(len(query)))
+	copy(buf[2:], query)
+	if _, err := stream.Write(buf); err != nil {
+		return nil, 0, err
+	}
+	// 发送完查询后必须关闭发送方向
+	stream.Close()
+
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		return nil, 0, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, resp); err != nil {
+		return nil, 0, err
+	}
+	return resp, handshake, nil
+}
+
+// 只保留连接相关设置的副本，用于内部的辅助查询，不带 EDNS 选项，也不检查应答
+func (this *DnsPing) plain() *DnsPing {
+	p := NewDnsPing(this.host, this.Timeout)
+	p.ip = cloneIP(this.ip)
+	p.Port = this.Port
+	p.Net = this.Net
+	p.Insecure = this.Insecure
+	p.TOS = this.TOS
+	return p
+}
 
-	return &DnsPingResult{int(time.Since(t0).Milliseconds()), nil, ip}
+func (this *DnsPing) errorResult(err error) *DnsPingResult {
+	r := &DnsPingResult{}
+	r.Err = err
+	return r
 }
 
 func NewDnsPing(host string, timeout time.Duration) *DnsPing {
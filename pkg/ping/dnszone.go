@@ -0,0 +1,305 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+type DnsZoneServer struct {
+	Name   string
+	IP     net.IP
+	Time   int
+	Serial uint32
+	Err    error
+
+	// 额外检查的记录，仅设置了 DnsZoneCheck.Domain 时有效
+	Answer []string
+
+	// 服务器有响应，但不是该区域的权威服务器
+	Lame bool
+	// SOA serial 与其他服务器不一致
+	Stale bool
+	// 额外检查的记录与其他服务器不一致
+	Mismatch bool
+}
+
+func (this *DnsZoneServer) OK() bool {
+	return this.Err == nil && !this.Lame && !this.Stale && !this.Mismatch
+}
+
+func (this *DnsZoneServer) String() string {
+	s := this.Name + ": "
+	if this.IP != nil {
+		s = fmt.Sprintf("%s (%s): ", this.Name, this.IP.String())
+	}
+	if this.Err != nil {
+		return s + this.Err.Error()
+	}
+	if this.Lame {
+		return s + fmt.Sprintf("lame, time=%d ms", this.Time)
+	}
+	s += fmt.Sprintf("serial=%d", this.Serial)
+	if this.Stale {
+		s += " (stale)"
+	}
+	if this.Answer != nil {
+		s += fmt.Sprintf(", answer=[%s]", strings.Join(this.Answer, ", "))
+		if this.Mismatch {
+			s += " (mismatch)"
+		}
+	}
+	return s + fmt.Sprintf(", time=%d ms", this.Time)
+}
+
+// 检查区域的所有权威服务器是否一致
+type DnsZoneCheck struct {
+	// 用于查询 NS 记录及其地址的递归服务器，host 为空时使用系统解析器。
+	// 查询权威服务器时使用其 Timeout、TOS 及 Net（仅 tcp），不使用 Port，权威服务器总是使用 53 端口
+	Resolver *DnsPing
+	Zone     string
+
+	// 可选，在每个权威服务器上额外检查的记录
+	Type   string
+	Domain string
+
+	// 只检查 IPv4 或 IPv6 地址的服务器，都为 false 时检查两者
+	IPv4 bool
+	IPv6 bool
+}
+
+func NewDnsZoneCheck(resolver *DnsPing, zone string) *DnsZoneCheck {
+	return &DnsZoneCheck{
+		Resolver: resolver,
+		Zone:     zone,
+		Type:     "A",
+	}
+}
+
+func (this *DnsZoneCheck) Check() ([]*DnsZoneServer, error) {
+	return this.CheckContext(context.Background())
+}
+
+func (this *DnsZoneCheck) CheckContext(ctx context.Context) ([]*DnsZoneServer, error) {
+	zone := dns.Fqdn(this.Zone)
+	lookup, err := this.lookupFunc()
+	if err != nil {
+		return nil, err
+	}
+	names, err := lookup(ctx, zone, "NS")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: no NS records", zone)
+	}
+	sort.Strings(names)
+
+	var qtypes []string
+	if this.IPv4 || !this.IPv6 {
+		qtypes = append(qtypes, "A")
+	}
+	if this.IPv6 || !this.IPv4 {
+		qtypes = append(qtypes, "AAAA")
+	}
+	var servers []*DnsZoneServer
+	for _, name := range names {
+		var ips []string
+		var lookuperr error
+		for _, qtype := range qtypes {
+			addrs, err := lookup(ctx, name, qtype)
+			if err != nil {
+				lookuperr = err
+				continue
+			}
+			ips = append(ips, addrs...)
+		}
+		// 只在没有任何地址时记录查询错误
+		if len(ips) == 0 {
+			if lookuperr == nil {
+				lookuperr = errors.New("no address")
+			}
+			servers = append(servers, &DnsZoneServer{Name: name, Err: lookuperr})
+		}
+		for _, ip := range ips {
+			servers = append(servers, &DnsZoneServer{Name: name, IP: net.ParseIP(ip)})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		if s.Err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(s *DnsZoneServer) {
+			defer wg.Done()
+			this.checkServer(ctx, zone, s)
+		}(s)
+	}
+	wg.Wait()
+
+	// 以最新的 serial 为准，以多数服务器的应答为准
+	var maxserial uint32
+	first := true
+	answers := map[string]int{}
+	for _, s := range servers {
+		if s.Err != nil || s.Lame {
+			continue
+		}
+		if first || serialLess(maxserial, s.Serial) {
+			maxserial = s.Serial
+			first = false
+		}
+		if s.Answer != nil {
+			answers[strings.Join(s.Answer, ",")]++
+		}
+	}
+	var answer string
+	for k, v := range answers {
+		if v > answers[answer] || (v == answers[answer] && k < answer) {
+			answer = k
+		}
+	}
+	for _, s := range servers {
+		if s.Err != nil || s.Lame {
+			continue
+		}
+		s.Stale = s.Serial != maxserial
+		s.Mismatch = s.Answer != nil && strings.Join(s.Answer, ",") != answer
+	}
+	return servers, nil
+}
+
+// 按 RFC 1982 序列号算术比较 a 是否早于 b
+func serialLess(a, b uint32) bool {
+	return a != b && int32(b-a) > 0
+}
+
+// 返回查询 NS 记录及地址的函数，结果为排序后的数据部分
+func (this *DnsZoneCheck) lookupFunc() (func(ctx context.Context, name, qtype string) ([]string, error), error) {
+	if this.Resolver.host == "" && this.Resolver.ip == nil {
+		return lookupSystem, nil
+	}
+	// 发现服务器的查询不应受 ExpectRcode 等设置影响
+	resolver := this.Resolver.plain()
+	ip := cloneIP(resolver.ip)
+	if ip == nil {
+		var err error
+		ip, err = LookupFunc(resolver.host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return func(ctx context.Context, name, qtype string) ([]string, error) {
+		return resolve(ctx, resolver, ip, name, qtype)
+	}, nil
+}
+
+// 通过系统解析器查询 NS、A 或 AAAA 记录
+func lookupSystem(ctx context.Context, name, qtype string) ([]string, error) {
+	var list []string
+	switch qtype {
+	case "NS":
+		nss, err := net.DefaultResolver.LookupNS(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range nss {
+			list = append(list, dns.Fqdn(ns.Host))
+		}
+	case "A", "AAAA":
+		network := "ip4"
+		if qtype == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := net.DefaultResolver.LookupIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			list = append(list, ip.String())
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %s", qtype)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// 通过递归服务器查询记录，返回排序后的数据部分
+func resolve(ctx context.Context, resolver *DnsPing, ip net.IP, name, qtype string) ([]string, error) {
+	r := resolver.query(ctx, ip, name, qtype)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%s: %s", name, dns.RcodeToString[r.Rcode])
+	}
+	return rdataList(r.Answer, dns.StringToType[qtype]), nil
+}
+
+func (this *DnsZoneCheck) checkServer(ctx context.Context, zone string, s *DnsZoneServer) {
+	p := NewDnsPing(s.IP.String(), this.Resolver.Timeout)
+	if this.Resolver.Net == "tcp" {
+		p.Net = "tcp"
+	}
+	p.TOS = this.Resolver.TOS
+	p.NoRecursion = true
+	r := p.query(ctx, s.IP, zone, "SOA")
+	if r.Err != nil {
+		s.Err = r.Err
+		return
+	}
+	s.Time = r.Time
+	if r.Rcode != dns.RcodeSuccess || !r.Authoritative {
+		s.Lame = true
+		return
+	}
+	var soa *dns.SOA
+	for _, rr := range r.Answer {
+		if rr, ok := rr.(*dns.SOA); ok {
+			soa = rr
+		}
+	}
+	if soa == nil {
+		s.Lame = true
+		return
+	}
+	s.Serial = soa.Serial
+
+	if this.Domain == "" {
+		return
+	}
+	qtype, ok := dns.StringToType[this.Type]
+	if !ok {
+		s.Err = errors.New("unknown type")
+		return
+	}
+	r = p.query(ctx, s.IP, this.Domain, this.Type)
+	if r.Err != nil {
+		s.Err = r.Err
+		return
+	}
+	s.Answer = rdataList(r.Answer, qtype)
+	if s.Answer == nil {
+		s.Answer = []string{}
+	}
+}
+
+func rdataList(rrs []dns.RR, qtype uint16) []string {
+	var list []string
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			list = append(list, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,73 @@
+package ping
+
+import (
+	"net/http"
+	"strings"
+)
+
+// 用于识别 CDN 节点的响应头
+var EdgeHeaders = []string{
+	"Server",
+	"Via",
+	"CF-Ray",
+	"X-Amz-Cf-Pop",
+	"X-Served-By",
+	"Age",
+	"X-Cache",
+	"CF-Cache-Status",
+	"Server-Timing",
+}
+
+// 只保留 EdgeHeaders 中的响应头
+func edgeHeader(h http.Header) http.Header {
+	r := make(http.Header)
+	for _, k := range EdgeHeaders {
+		if v := h.Values(k); len(v) > 0 {
+			r[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	return r
+}
+
+// 最后一个以逗号分隔的值，多级缓存时为离客户端最近的一级
+func lastValue(s string) string {
+	if i := strings.LastIndex(s, ","); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.TrimSpace(s)
+}
+
+// 响应的 CDN 节点，如 Cloudflare 和 Fastly 的机场代码、CloudFront 的节点名，未知时为空
+func (this *HttpPingResult) Pop() string {
+	if v := this.Header.Get("CF-Ray"); v != "" {
+		if i := strings.LastIndex(v, "-"); i >= 0 {
+			return v[i+1:]
+		}
+	}
+	if v := this.Header.Get("X-Amz-Cf-Pop"); v != "" {
+		return v
+	}
+	if v := lastValue(this.Header.Get("X-Served-By")); v != "" {
+		if i := strings.LastIndex(v, "-"); i >= 0 {
+			return v[i+1:]
+		}
+		return v
+	}
+	return ""
+}
+
+// 缓存状态，命中或未命中时为 HIT 或 MISS，否则为原始值的大写，如 DYNAMIC，未知时为空
+func (this *HttpPingResult) CacheStatus() string {
+	v := this.Header.Get("CF-Cache-Status")
+	if v == "" {
+		v = lastValue(this.Header.Get("X-Cache"))
+	}
+	v = strings.ToUpper(v)
+	switch {
+	case strings.Contains(v, "HIT"):
+		return "HIT"
+	case strings.Contains(v, "MISS"):
+		return "MISS"
+	}
+	return v
+}
@@ -2,12 +2,16 @@ package ping
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/quic-go/quic-go"
@@ -21,6 +25,27 @@ type HttpPingResult struct {
 	Length int
 	Err    error
 	IP     net.IP
+
+	// 读取响应体阶段的下载速度，字节每秒
+	Speed float64
+	// 达到 MaxBytes 或 MaxDuration 后提前结束，未读完响应体
+	Truncated bool
+
+	// 以下仅在上传时有效
+	// 上传的大小
+	Uploaded int64
+	// 发送请求体的时间
+	WriteTime int
+	// 请求体发送完成后等待响应头的时间
+	WaitTime int
+	// 上传速度，字节每秒
+	UploadSpeed float64
+
+	// 自动升级模式下协议切换的原因
+	Note string
+
+	// EdgeHeaders 中的响应头
+	Header http.Header
 }
 
 func (this *HttpPingResult) Result() int {
@@ -35,7 +60,40 @@ func (this *HttpPingResult) String() string {
 	if this.Err != nil {
 		return fmt.Sprintf("%s", this.Err)
 	} else {
-		return fmt.Sprintf("%s: protocol=%s, status=%d, length=%d, time=%d ms", this.IP.String(), this.Proto, this.Status, this.Length, this.Time)
+		s := fmt.Sprintf("%s: protocol=%s, status=%d, length=%d, time=%d ms", this.IP.String(), this.Proto, this.Status, this.Length, this.Time)
+		if this.Length > 0 {
+			s += fmt.Sprintf(", speed=%s", FormatSpeed(this.Speed))
+		}
+		if this.Truncated {
+			s += " (truncated)"
+		}
+		if pop := this.Pop(); pop != "" {
+			s += fmt.Sprintf(", pop=%s", pop)
+		}
+		if cache := this.CacheStatus(); cache != "" {
+			s += fmt.Sprintf(", cache=%s", cache)
+		}
+		if this.Uploaded > 0 {
+			s += fmt.Sprintf(", uploaded=%d, write=%d ms, wait=%d ms, upload speed=%s", this.Uploaded, this.WriteTime, this.WaitTime, FormatSpeed(this.UploadSpeed))
+		}
+		if this.Note != "" {
+			s += fmt.Sprintf(" [%s]", this.Note)
+		}
+		return s
+	}
+}
+
+// 以 1024 为进制格式化下载速度
+func FormatSpeed(bytesPerSec float64) string {
+	switch {
+	case bytesPerSec >= 1<<30:
+		return fmt.Sprintf("%.2f GB/s", bytesPerSec/(1<<30))
+	case bytesPerSec >= 1<<20:
+		return fmt.Sprintf("%.2f MB/s", bytesPerSec/(1<<20))
+	case bytesPerSec >= 1<<10:
+		return fmt.Sprintf("%.2f KB/s", bytesPerSec/(1<<10))
+	default:
+		return fmt.Sprintf("%.0f B/s", bytesPerSec)
 	}
 }
 
@@ -52,6 +110,100 @@ type HttpPing struct {
 	UserAgent          string
 	Http3              bool
 	IP                 net.IP
+	TOS                int
+
+	// 最多读取的响应体大小，为 0 时不限制
+	MaxBytes int64
+	// 读取响应体的最长时间，达到后停止读取，不视为错误。
+	// 为 0 时 Timeout 限制整个请求；否则 Timeout 只限制收到响应头之前的时间
+	MaxDuration time.Duration
+
+	// 上传的请求体，UploadFile 不为空时读取文件，否则生成 UploadSize 字节的随机数据。
+	// 上传时 Method 应为 POST 或 PUT，Timeout 包括上传的时间
+	UploadSize int64
+	UploadFile string
+
+	// 模拟浏览器，先通过 TCP 请求，根据 Alt-Svc 响应头切换到 HTTP/3，仅对 https 有效
+	AutoHttp3 bool
+	// 可选，AutoHttp3 时额外查询 HTTPS 记录的递归服务器
+	HttpsResolver *DnsPing
+
+	mu   sync.Mutex
+	auto autoState
+}
+
+// 请求体，记录开始和结束读取的时间，即开始和完成发送的时间
+type uploadBody struct {
+	r io.Reader
+	c io.Closer
+
+	mu         sync.Mutex
+	n          int64
+	start, end time.Time
+}
+
+func (this *uploadBody) Read(p []byte) (int, error) {
+	this.mu.Lock()
+	if this.start.IsZero() {
+		this.start = time.Now()
+	}
+	this.mu.Unlock()
+	n, err := this.r.Read(p)
+	this.mu.Lock()
+	this.n += int64(n)
+	if err == io.EOF && this.end.IsZero() {
+		this.end = time.Now()
+	}
+	this.mu.Unlock()
+	return n, err
+}
+
+func (this *uploadBody) Close() error {
+	if this.c != nil {
+		return this.c.Close()
+	}
+	return nil
+}
+
+// 返回已发送的大小，及开始和完成发送的时间，未完成时 end 为零值
+func (this *uploadBody) stats() (n int64, start, end time.Time) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.n, this.start, this.end
+}
+
+// 重复一块随机数据，避免生成大量随机数
+type randomReader struct {
+	block []byte
+	off   int
+}
+
+func (this *randomReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		m := copy(p[n:], this.block[this.off:])
+		n += m
+		this.off = (this.off + m) % len(this.block)
+	}
+	return n, nil
+}
+
+func (this *HttpPing) newUploadBody() (*uploadBody, int64, error) {
+	if this.UploadFile != "" {
+		f, err := os.Open(this.UploadFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return &uploadBody{r: f, c: f}, fi.Size(), nil
+	}
+	block := make([]byte, 32*1024)
+	rand.Read(block)
+	return &uploadBody{r: io.LimitReader(&randomReader{block: block}, this.UploadSize)}, this.UploadSize, nil
 }
 
 func (this *HttpPing) Ping() IPingResult {
@@ -63,30 +215,43 @@ func (this *HttpPing) PingContext(ctx context.Context) IPingResult {
 	if err != nil {
 		return this.errorResult(err)
 	}
-	orighost := u.Host
-	host := u.Hostname()
-	port := u.Port()
 	ip := cloneIP(this.IP)
 	if ip == nil {
 		var err error
-		ip, err = LookupFunc(host)
+		ip, err = LookupFunc(u.Hostname())
 		if err != nil {
 			return this.errorResult(err)
 		}
 	}
+	if this.AutoHttp3 && u.Scheme == "https" {
+		return this.autoPing(ctx, u, ip)
+	}
+	result, _ := this.do(ctx, u, ip, this.Http3, "")
+	return result
+}
+
+// 向 ip 发送一次请求，同时返回响应头。h3port 不为空时 HTTP/3 连接到该端口
+func (this *HttpPing) do(ctx context.Context, u *url.URL, ip net.IP, useh3 bool, h3port string) (*HttpPingResult, http.Header) {
+	orighost := u.Host
+	host := u.Hostname()
+	port := u.Port()
+	if h3port != "" {
+		port = h3port
+	}
 	ipstr := ip.String()
 	if isIPv6(ip) {
 		ipstr = fmt.Sprintf("[%s]", ipstr)
 	}
+	u2 := *u
 	if port != "" {
-		u.Host = fmt.Sprintf("%s:%s", ipstr, port)
+		u2.Host = fmt.Sprintf("%s:%s", ipstr, port)
 	} else {
-		u.Host = ipstr
+		u2.Host = ipstr
 	}
-	url2 := u.String()
+	url2 := u2.String()
 
 	var transport http.RoundTripper
-	if this.Http3 {
+	if useh3 {
 		trans := &http3.Transport{
 			DisableCompression: this.DisableCompression,
 			QUICConfig: &quic.Config{
@@ -97,6 +262,9 @@ func (this *HttpPing) PingContext(ctx context.Context) IPingResult {
 				ServerName:         host,
 			},
 		}
+		if this.TOS > 0 {
+			trans.Dial = this.dialQuic
+		}
 		defer trans.Close()
 		transport = trans
 	} else {
@@ -109,12 +277,44 @@ func (this *HttpPing) PingContext(ctx context.Context) IPingResult {
 			InsecureSkipVerify: this.Insecure,
 			ServerName:         host,
 		}
+		if this.TOS > 0 {
+			// 与 http.DefaultTransport 相同
+			dialer := &net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				Control:   tosControl(this.TOS),
+			}
+			trans.DialContext = dialer.DialContext
+		}
 		transport = trans
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// 由定时器取消请求，以区分超时和提前结束读取
+	var timedout, capped atomic.Bool
+	timer := time.AfterFunc(this.Timeout, func() {
+		timedout.Store(true)
+		cancel()
+	})
+	defer func() {
+		timer.Stop()
+	}()
+
+	var upload *uploadBody
 	req, err := http.NewRequestWithContext(ctx, this.Method, url2, nil)
 	if err != nil {
-		return this.errorResult(err)
+		return this.errorResult(err), nil
+	}
+	if this.UploadFile != "" || this.UploadSize > 0 {
+		var size int64
+		upload, size, err = this.newUploadBody()
+		if err != nil {
+			return this.errorResult(err), nil
+		}
+		req.Body = upload
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
 	}
 	ua := "httping"
 	if this.UserAgent != "" {
@@ -127,21 +327,95 @@ func (this *HttpPing) PingContext(ctx context.Context) IPingResult {
 	req.Host = orighost
 	client := &http.Client{}
 	client.Transport = transport
-	client.Timeout = this.Timeout
 	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 		return http.ErrUseLastResponse
 	}
+	wrap := func(err error) error {
+		if timedout.Load() {
+			return fmt.Errorf("%s: %w", ip.String(), ErrTimeout)
+		}
+		return err
+	}
 	t0 := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		return this.errorResult(err)
+		return this.errorResult(wrap(err)), nil
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	t1 := time.Now()
+	var uploaded int64
+	var ustart, uend time.Time
+	if upload != nil {
+		uploaded, ustart, uend = upload.stats()
+		// 服务器在请求体发送完成前就已响应
+		if uend.IsZero() {
+			uend = t1
+		}
+		if ustart.IsZero() {
+			ustart = uend
+		}
+	}
+	if this.MaxDuration > 0 {
+		timer.Stop()
+		timer = time.AfterFunc(this.MaxDuration, func() {
+			capped.Store(true)
+			cancel()
+		})
+	}
+
+	// 不缓存响应体，只统计大小
+	var body io.Reader = resp.Body
+	if this.MaxBytes > 0 {
+		body = io.LimitReader(resp.Body, this.MaxBytes)
+	}
+	n, err := io.Copy(io.Discard, body)
+	if err != nil && !capped.Load() {
+		return this.errorResult(wrap(err)), nil
+	}
+	t2 := time.Now()
+	result := &HttpPingResult{
+		Time:      int(t2.Sub(t0).Milliseconds()),
+		Proto:     resp.Proto,
+		Status:    resp.StatusCode,
+		Length:    int(n),
+		IP:        ip,
+		Truncated: capped.Load(),
+		Header:    edgeHeader(resp.Header),
+	}
+	if d := t2.Sub(t1); d > 0 {
+		result.Speed = float64(n) / d.Seconds()
+	}
+	if upload != nil {
+		result.Uploaded = uploaded
+		result.WriteTime = int(uend.Sub(ustart).Milliseconds())
+		result.WaitTime = int(t1.Sub(uend).Milliseconds())
+		if d := uend.Sub(ustart); d > 0 {
+			result.UploadSpeed = float64(uploaded) / d.Seconds()
+		}
+	}
+	// 达到 MaxBytes 时，除非响应体恰好为此大小，否则认为未读完
+	if this.MaxBytes > 0 && n == this.MaxBytes && resp.ContentLength != n {
+		result.Truncated = true
+	}
+	return result, resp.Header
+}
+
+// 使用设置了 TOS 的 UDP 连接，QUIC 连接关闭后同时关闭 UDP 连接
+func (this *HttpPing) dialQuic(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	udpconn, udpaddr, err := listenUDPTOS(addr, this.TOS)
 	if err != nil {
-		return this.errorResult(err)
+		return nil, err
+	}
+	conn, err := quic.DialEarly(ctx, udpconn, udpaddr, tlsCfg, cfg)
+	if err != nil {
+		udpconn.Close()
+		return nil, err
 	}
-	return &HttpPingResult{int(time.Since(t0).Milliseconds()), resp.Proto, resp.StatusCode, len(body), nil, ip}
+	go func() {
+		<-conn.Context().Done()
+		udpconn.Close()
+	}()
+	return conn, nil
 }
 
 func (this *HttpPing) errorResult(err error) *HttpPingResult {
@@ -1,14 +1,13 @@
 package ping
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
-	"os"
-	"syscall"
+	"sync"
 	"time"
 
 	"golang.org/x/net/icmp"
@@ -21,6 +20,14 @@ type IcmpPingResult struct {
 	Err  error
 	IP   net.IP
 	TTL  int
+	Seq  int
+
+	// 以下仅用于 IcmpPing.OnLateReply
+	Dup  bool
+	Late bool
+
+	// 回复晚于序号更大的回复到达
+	Reordered bool
 }
 
 func (this *IcmpPingResult) Result() int {
@@ -31,14 +38,73 @@ func (this *IcmpPingResult) Error() error {
 	return this.Err
 }
 
+// 回复该请求的主机，即目标或途经的路由器，未收到回复时返回 nil
+func (this *IcmpPingResult) Responder() net.IP {
+	if this.Err == nil || errors.Is(this.Err, ErrTimeExceeded) || errors.Is(this.Err, ErrDestinationUnreachable) {
+		return this.IP
+	}
+	return nil
+}
+
 func (this *IcmpPingResult) String() string {
 	if this.Err != nil {
 		return fmt.Sprintf("%s", this.Err)
+	} else if this.Late {
+		if this.Time < 0 {
+			return fmt.Sprintf("%s: seq=%d, late reply", this.IP.String(), this.Seq)
+		}
+		return fmt.Sprintf("%s: seq=%d, time=%d ms, late reply", this.IP.String(), this.Seq, this.Time)
 	} else {
-		return fmt.Sprintf("%s: time=%d ms, TTL=%d", this.IP.String(), this.Time, this.TTL)
+		s := fmt.Sprintf("%s: seq=%d, time=%d ms, TTL=%d", this.IP.String(), this.Seq, this.Time, this.TTL)
+		if this.Dup {
+			s += " (DUP!)"
+		}
+		if this.Reordered {
+			s += " (out of order)"
+		}
+		return s
+	}
+}
+
+var (
+	ErrTimeout                = errors.New("request timed out")
+	ErrTimeExceeded           = errors.New("time exceeded")
+	ErrDestinationUnreachable = errors.New("destination unreachable")
+)
+
+// 设置了 DontFragment 而包超过了路径 MTU
+type FragmentationNeededError struct {
+	// 发出差错报文的路由器，本地发送失败时为空
+	IP net.IP
+	// 路由器通告的下一跳 MTU，未知时为 0
+	MTU int
+}
+
+func (this *FragmentationNeededError) Error() string {
+	s := "fragmentation needed"
+	if this.IP != nil {
+		s = this.IP.String() + ": " + s
+	}
+	if this.MTU > 0 {
+		s += fmt.Sprintf(", mtu=%d", this.MTU)
 	}
+	return s
+}
+
+func (this *FragmentationNeededError) Unwrap() error {
+	return ErrDestinationUnreachable
+}
+
+type IcmpStatistics struct {
+	// 重复的回复
+	Duplicates int
+	// 超时后才到达的回复
+	Late int
+	// 乱序到达的回复
+	Reordered int
 }
 
+// 包含互斥锁，只能通过指针使用，不能复制
 type IcmpPing struct {
 	host    string
 	Timeout time.Duration
@@ -47,6 +113,29 @@ type IcmpPing struct {
 	Privileged bool
 	TTL        int
 	Size       int
+
+	// 设置 DF 标志，禁止路由器分片
+	DontFragment bool
+	// IPv4 的 TOS 或 IPv6 的 Traffic Class，DSCP 需左移 2 位
+	TOS int
+	// 循环填充数据的内容，为空时使用随机数据
+	Pattern []byte
+	// 在数据开头写入发送时间，超时后才到达的回复也能计算延迟。需要 Size 不小于 8
+	Timestamp bool
+
+	// 收到重复或超时后才到达的回复时调用，在接收协程中执行
+	OnLateReply func(*IcmpPingResult)
+
+	// 共享的 ICMP 连接，为空时使用包内按模式共享的默认连接。Privileged 仍然决定发送方式，应与 Engine 的模式一致，
+	// 如 Windows 上非特权模式使用 IcmpSendEcho，不会使用 Engine
+	Engine *IcmpEngine
+
+	mu       sync.Mutex
+	id       int
+	idengine *IcmpEngine
+	seq      int
+	maxreply int
+	stats    IcmpStatistics
 }
 
 func (this *IcmpPing) SetHost(host string) {
@@ -60,8 +149,9 @@ func (this *IcmpPing) Host() string {
 
 func NewIcmpPing(host string, timeout time.Duration) *IcmpPing {
 	p := &IcmpPing{
-		Timeout: timeout,
-		Size:    32,
+		Timeout:  timeout,
+		Size:     32,
+		maxreply: -1,
 	}
 	p.SetHost(host)
 	return p
@@ -79,122 +169,123 @@ func (this *IcmpPing) PingContext(ctx context.Context) IPingResult {
 	return pingfunc(ctx)
 }
 
+// IcmpPing 不持有连接，无需关闭，保留以兼容 io.Closer。不会关闭 Engine
+func (this *IcmpPing) Close() error {
+	return nil
+}
+
+func (this *IcmpPing) Statistics() IcmpStatistics {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.stats
+}
+
 func (this *IcmpPing) ping_root(ctx context.Context) IPingResult {
-	return this.rawping("ip")
+	return this.rawping(ctx, "ip")
 }
 
 // https://github.com/sparrc/go-ping/blob/master/ping.go
 
-func (this *IcmpPing) rawping(network string) IPingResult {
+func (this *IcmpPing) rawping(ctx context.Context, network string) IPingResult {
 	// 解析IP
 	ip, isipv6, err := this.parseip()
 	if err != nil {
 		return this.errorResult(err)
 	}
+	if err := ctx.Err(); err != nil {
+		return this.errorResult(err)
+	}
 
-	// 创建连接
-	conn, err := this.getconn(network, isipv6)
+	// 获取连接，连接在多次 ping 之间保持打开
+	engine, id := this.getengine(network)
+	conn, err := engine.getconn(isipv6)
 	if err != nil {
 		return this.errorResult(err)
 	}
-	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(this.Timeout))
 
 	// 发送
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	sendData := make([]byte, this.Size)
-	r.Read(sendData)
-	id := os.Getpid() & 0xffff
-	sendMsg := this.getmsg(isipv6, id, 0, sendData)
-	sendMsgBytes, err := sendMsg.Marshal(nil)
+	sendData := this.payload()
+	this.mu.Lock()
+	seq := this.seq
+	this.seq++
+	this.mu.Unlock()
+	probe, err := conn.send(this, id, ip, seq, sendData, this.TTL, this.DontFragment, this.TOS)
 	if err != nil {
 		return this.errorResult(err)
 	}
-	var dst net.Addr = &net.IPAddr{IP: ip}
-	if network == "udp" {
-		dst = &net.UDPAddr{IP: ip}
+	return conn.wait(ctx, probe, this.Timeout)
+}
+
+func (this *IcmpPing) getengine(network string) (*IcmpEngine, int) {
+	engine := this.Engine
+	if engine == nil {
+		engine = defaultIcmpEngine(network == "ip")
 	}
-	sendAt := time.Now()
-	for {
-		if _, err := conn.WriteTo(sendMsgBytes, dst); err != nil {
-			if neterr, ok := err.(*net.OpError); ok {
-				if neterr.Err == syscall.ENOBUFS {
-					continue
-				}
-			}
-		}
-		break
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.idengine != engine {
+		this.id = engine.allocid()
+		this.idengine = engine
 	}
+	return engine, this.id
+}
 
-	// 直接分配一个足够大的缓冲区
-	recvBytes := make([]byte, this.Size+128)
-	recvSize := 0
-
-	for {
-		ttl := -1
-		var peer net.Addr
-		if isipv6 {
-			var cm *ipv6.ControlMessage
-			recvSize, cm, peer, err = conn.IPv6PacketConn().ReadFrom(recvBytes)
-			if cm != nil {
-				ttl = cm.HopLimit
-			}
+// 由接收协程调用，统计乱序、重复及超时的回复
+func (this *IcmpPing) onreply(result *IcmpPingResult, state int) {
+	this.mu.Lock()
+	switch state {
+	case probePending:
+		if result.Seq < this.maxreply {
+			result.Reordered = true
+			this.stats.Reordered++
 		} else {
-			var cm *ipv4.ControlMessage
-			recvSize, cm, peer, err = conn.IPv4PacketConn().ReadFrom(recvBytes)
-			if cm != nil {
-				ttl = cm.TTL
-			}
-		}
-		if err != nil {
-			return this.errorResult(err)
+			this.maxreply = result.Seq
 		}
+	case probeReplied:
+		result.Dup = true
+		this.stats.Duplicates++
+	case probeTimedout:
+		result.Late = true
+		this.stats.Late++
+	}
+	f := this.OnLateReply
+	this.mu.Unlock()
+	if state != probePending && f != nil {
+		f(result)
+	}
+}
 
-		recvAt := time.Now()
-		recvProto := 1
-		if isipv6 {
-			recvProto = 58
-		}
-		recvMsg, err := icmp.ParseMessage(recvProto, recvBytes[:recvSize])
-		if err != nil {
-			return this.errorResult(err)
-		}
-		recvData, recvID, recvType := this.parserecvmsg(isipv6, recvMsg)
-		// 是 echo 回复，但 ID 不一致，继续接收
-		if recvType == 1 && network == "ip" && recvID != id {
-			continue
-		}
-		// 无脑修正数据长度，开头数据其实为 IP 包头
-		if len(recvData) > len(sendData) {
-			recvData = recvData[len(recvData)-len(sendData):]
-		}
-		// 收到的数据和发送的数据不一致，继续接收
-		if !bytes.Equal(recvData, sendData) {
-			continue
-		}
+const icmpTimestampLen = 8
 
-		if peer != nil {
-			if _ip := net.ParseIP(peer.String()); _ip != nil {
-				ip = _ip
-			}
+// 生成发送的数据
+func (this *IcmpPing) payload() []byte {
+	data := make([]byte, this.Size)
+	off := 0
+	if this.Timestamp && len(data) >= icmpTimestampLen {
+		binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+		off = icmpTimestampLen
+	}
+	if len(this.Pattern) > 0 {
+		for i := off; i < len(data); i++ {
+			data[i] = this.Pattern[(i-off)%len(this.Pattern)]
 		}
+	} else {
+		rand.Read(data[off:])
+	}
+	return data
+}
 
-		switch recvType {
-		case 1:
-			// echo
-			return &IcmpPingResult{
-				TTL:  ttl,
-				Time: int(recvAt.Sub(sendAt).Milliseconds()),
-				IP:   ip,
-			}
-		case 2:
-			// destination unreachable
-			return this.errorResult(fmt.Errorf("%s: destination unreachable", ip.String()))
-		case 3:
-			// time exceeded
-			return this.errorResult(fmt.Errorf("%s: time exceeded", ip.String()))
-		}
+// 从回复的数据中取出发送时间计算延迟，数据无效时返回 -1
+func timestampRTT(data []byte, recvAt time.Time) int {
+	if len(data) < icmpTimestampLen {
+		return -1
 	}
+	sendAt := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+	d := recvAt.Sub(sendAt)
+	if d < 0 || d > time.Hour {
+		return -1
+	}
+	return int(d.Milliseconds())
 }
 
 func (this *IcmpPing) parseip() (ip net.IP, ipv6 bool, err error) {
@@ -216,35 +307,7 @@ func (this *IcmpPing) parseip() (ip net.IP, ipv6 bool, err error) {
 	return
 }
 
-func (this *IcmpPing) getconn(network string, isipv6 bool) (*icmp.PacketConn, error) {
-	ipv4Proto := map[string]string{"ip": "ip4:icmp", "udp": "udp4"}
-	ipv6Proto := map[string]string{"ip": "ip6:ipv6-icmp", "udp": "udp6"}
-	icmpnetwork := ""
-	if isipv6 {
-		icmpnetwork = ipv6Proto[network]
-	} else {
-		icmpnetwork = ipv4Proto[network]
-	}
-	conn, err := icmp.ListenPacket(icmpnetwork, "")
-	if err != nil {
-		return nil, err
-	}
-	if isipv6 {
-		conn.IPv6PacketConn().SetControlMessage(ipv6.FlagHopLimit, true)
-	} else {
-		conn.IPv4PacketConn().SetControlMessage(ipv4.FlagTTL, true)
-	}
-	if this.TTL > 0 {
-		if isipv6 {
-			conn.IPv6PacketConn().SetHopLimit(this.TTL)
-		} else {
-			conn.IPv4PacketConn().SetTTL(this.TTL)
-		}
-	}
-	return conn, nil
-}
-
-func (this *IcmpPing) getmsg(isipv6 bool, id, seq int, data []byte) *icmp.Message {
+func getmsg(isipv6 bool, id, seq int, data []byte) *icmp.Message {
 	var msgtype icmp.Type = ipv4.ICMPTypeEcho
 	if isipv6 {
 		msgtype = ipv6.ICMPTypeEchoRequest
@@ -262,45 +325,116 @@ func (this *IcmpPing) getmsg(isipv6 bool, id, seq int, data []byte) *icmp.Messag
 	return msg
 }
 
-func (this *IcmpPing) parserecvmsg(isipv6 bool, msg *icmp.Message) (data []byte, id, msgtype int) {
-	id = 0
-	data = nil
-	msgtype = 0
+// 解析后的 ICMP 报文，msgtype 为 1: echo 回复，2: 目标不可达，3: 超时，4: 需要分片
+type icmpMessage struct {
+	msgtype int
+	id      int
+	seq     int
+	dst     net.IP
+	data    []byte
+	peer    net.IP
+	ttl     int
+	// 需要分片时通告的 MTU
+	mtu int
+
+	// 差错报文中原始请求的传输层头部
+	hdr []byte
+}
+
+// b 为原始报文，x/net/icmp 不保留目标不可达报文中的 MTU 字段
+func parserecvmsg(isipv6 bool, msg *icmp.Message, b []byte) *icmpMessage {
+	m := &icmpMessage{ttl: -1}
 	if isipv6 {
 		switch msg.Type {
 		case ipv6.ICMPTypeEchoReply:
-			msgtype = 1
+			m.msgtype = 1
 		case ipv6.ICMPTypeDestinationUnreachable:
-			msgtype = 2
+			m.msgtype = 2
 		case ipv6.ICMPTypeTimeExceeded:
-			msgtype = 3
+			m.msgtype = 3
+		case ipv6.ICMPTypePacketTooBig:
+			m.msgtype = 4
 		}
 	} else {
 		switch msg.Type {
 		case ipv4.ICMPTypeEchoReply:
-			msgtype = 1
+			m.msgtype = 1
 		case ipv4.ICMPTypeDestinationUnreachable:
-			msgtype = 2
+			m.msgtype = 2
+			if msg.Code == 4 && len(b) >= 8 {
+				m.msgtype = 4
+				m.mtu = int(binary.BigEndian.Uint16(b[6:8]))
+			}
 		case ipv4.ICMPTypeTimeExceeded:
-			msgtype = 3
+			m.msgtype = 3
 		}
 	}
-	switch msgtype {
+	var data []byte
+	switch m.msgtype {
 	case 1:
 		if tempmsg, ok := msg.Body.(*icmp.Echo); ok {
-			data = tempmsg.Data
-			id = tempmsg.ID
+			m.data = tempmsg.Data
+			m.id = tempmsg.ID
+			m.seq = tempmsg.Seq
 		}
+		return m
 	case 2:
 		if tempmsg, ok := msg.Body.(*icmp.DstUnreach); ok {
 			data = tempmsg.Data
 		}
+	case 4:
+		if tempmsg, ok := msg.Body.(*icmp.DstUnreach); ok {
+			data = tempmsg.Data
+		} else if tempmsg, ok := msg.Body.(*icmp.PacketTooBig); ok {
+			data = tempmsg.Data
+			m.mtu = tempmsg.MTU
+		}
 	case 3:
 		if tempmsg, ok := msg.Body.(*icmp.TimeExceeded); ok {
 			data = tempmsg.Data
 		}
 	}
-	return
+	// 差错报文中包含原始的 IP 包头及 echo 请求，从中取出目标地址、ID 和序号
+	m.dst, m.hdr = parseembedded(isipv6, data)
+	m.id, m.seq = -1, -1
+	if m.hdr != nil {
+		m.id = int(binary.BigEndian.Uint16(m.hdr[4:6]))
+		m.seq = int(binary.BigEndian.Uint16(m.hdr[6:8]))
+		m.data = m.hdr[8:]
+	}
+	return m
+}
+
+// 差错报文对应的错误，ip 为发出差错报文的主机
+func icmpError(msg *icmpMessage, ip net.IP) error {
+	switch msg.msgtype {
+	case 3:
+		return fmt.Errorf("%s: %w", ip.String(), ErrTimeExceeded)
+	case 4:
+		return &FragmentationNeededError{IP: ip, MTU: msg.mtu}
+	}
+	return fmt.Errorf("%s: %w", ip.String(), ErrDestinationUnreachable)
+}
+
+// 解析差错报文中的原始 IP 包，返回目标地址及至少 8 字节的传输层头部
+func parseembedded(isipv6 bool, b []byte) (dst net.IP, hdr []byte) {
+	hdrlen := ipv6.HeaderLen
+	if isipv6 {
+		if len(b) < ipv6.HeaderLen {
+			return nil, nil
+		}
+		dst = net.IP(b[24:40])
+	} else {
+		if len(b) < ipv4.HeaderLen {
+			return nil, nil
+		}
+		hdrlen = int(b[0]&0x0f) << 2
+		dst = net.IPv4(b[16], b[17], b[18], b[19])
+	}
+	if len(b) < hdrlen+8 {
+		return nil, nil
+	}
+	return dst, b[hdrlen:]
 }
 
 func (this *IcmpPing) errorResult(err error) IPingResult {
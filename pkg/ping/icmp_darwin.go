@@ -0,0 +1,37 @@
+//go:build darwin
+
+package ping
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	// IP_STRIPHDR，读取时去掉 IP 包头
+	sysIP_STRIPHDR   = 0x17
+	sysIP_DONTFRAG   = 0x1c
+	sysIPV6_DONTFRAG = 0x3e
+)
+
+func dgramSockopt(s int, isipv6 bool) error {
+	if isipv6 {
+		return nil
+	}
+	return syscall.SetsockoptInt(s, syscall.IPPROTO_IP, sysIP_STRIPHDR, 1)
+}
+
+func readErrQueue(raw syscall.RawConn, isipv6 bool) ([]*icmpMessage, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func setsockoptDF(fd uintptr, isipv6 bool, df bool) error {
+	v := 0
+	if df {
+		v = 1
+	}
+	if isipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, sysIPV6_DONTFRAG, v)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, sysIP_DONTFRAG, v)
+}
@@ -0,0 +1,561 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// IcmpEngine 持有一个 IPv4 和一个 IPv6 连接，按 (ID, 序号, 目标地址) 将回复分发给对应的请求，
+// 可以由多个 IcmpPing 同时共享，用于大量主机的并发 ping
+type IcmpEngine struct {
+	network string
+
+	mu     sync.Mutex
+	conns  [2]*icmpConn
+	nextid int
+	closed bool
+}
+
+func NewIcmpEngine(privileged bool) *IcmpEngine {
+	network := "udp"
+	if privileged {
+		network = "ip"
+	}
+	// 特权模式下所有进程共享回复，ID 从随机值开始，避免与其他进程及引擎冲突
+	return &IcmpEngine{network: network, nextid: rand.IntN(0x10000)}
+}
+
+var (
+	defaultEnginesMu sync.Mutex
+	defaultEngines   [2]*IcmpEngine
+)
+
+// 未设置 Engine 的 IcmpPing 共享的连接，每种模式一个，按需创建，不会关闭
+func defaultIcmpEngine(privileged bool) *IcmpEngine {
+	i := 0
+	if privileged {
+		i = 1
+	}
+	defaultEnginesMu.Lock()
+	defer defaultEnginesMu.Unlock()
+	if defaultEngines[i] == nil {
+		defaultEngines[i] = NewIcmpEngine(privileged)
+	}
+	return defaultEngines[i]
+}
+
+func (this *IcmpEngine) Privileged() bool {
+	return this.network == "ip"
+}
+
+func (this *IcmpEngine) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.closed = true
+	var err error
+	for i, c := range this.conns {
+		if c != nil {
+			if e := c.close(); e != nil {
+				err = e
+			}
+			this.conns[i] = nil
+		}
+	}
+	return err
+}
+
+// 为共享连接的 IcmpPing 分配不同的 ID，非特权模式下 ID 由系统分配，此值无意义
+func (this *IcmpEngine) allocid() int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.nextid = (this.nextid + 1) & 0xffff
+	return this.nextid
+}
+
+// 按需打开连接，连接出错后重新打开
+func (this *IcmpEngine) getconn(isipv6 bool) (*icmpConn, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed {
+		return nil, net.ErrClosed
+	}
+	i := 0
+	if isipv6 {
+		i = 1
+	}
+	if c := this.conns[i]; c != nil {
+		if !c.closed() {
+			return c, nil
+		}
+		c.close()
+	}
+	conn, err := listenIcmp(this.network, isipv6)
+	if err != nil {
+		return nil, err
+	}
+	this.conns[i], err = newIcmpConn(conn, this.network, isipv6)
+	return this.conns[i], err
+}
+
+// 不使用 icmp.ListenPacket，以便设置额外的 socket 选项
+func listenIcmp(network string, isipv6 bool) (net.PacketConn, error) {
+	if network == "udp" {
+		return listenIcmpDgram(isipv6)
+	}
+	if isipv6 {
+		return net.ListenPacket("ip6:ipv6-icmp", "::")
+	}
+	return net.ListenPacket("ip4:icmp", "0.0.0.0")
+}
+
+const (
+	probePending = iota
+	probeReplied
+	probeTimedout
+)
+
+type icmpKey struct {
+	id  int
+	seq int
+	dst string
+}
+
+type icmpProbe struct {
+	owner  *IcmpPing
+	key    icmpKey
+	dst    net.IP
+	seq    int
+	data   []byte
+	sendAt time.Time
+	state  int
+	reply  chan *IcmpPingResult
+	// 数据开头带有发送时间
+	stamped bool
+}
+
+// 一个 ICMP 连接及其接收协程
+type icmpConn struct {
+	conn    net.PacketConn
+	raw     syscall.RawConn
+	p4      *ipv4.PacketConn
+	p6      *ipv6.PacketConn
+	network string
+	isipv6  bool
+
+	// 保护发送及 TTL、DF、TOS 设置
+	wmu sync.Mutex
+	// 0 表示未修改过，defttl 为修改前的系统默认值
+	ttl    int
+	defttl int
+	df     bool
+	tos    int
+
+	mu        sync.Mutex
+	probes    map[icmpKey]*icmpProbe
+	nextseq   int
+	lastsweep time.Time
+	err       error
+}
+
+func newIcmpConn(conn net.PacketConn, network string, isipv6 bool) (*icmpConn, error) {
+	c := &icmpConn{
+		conn:      conn,
+		network:   network,
+		isipv6:    isipv6,
+		probes:    make(map[icmpKey]*icmpProbe),
+		lastsweep: time.Now(),
+	}
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.raw = raw
+	if isipv6 {
+		c.p6 = ipv6.NewPacketConn(conn)
+		c.p6.SetControlMessage(ipv6.FlagHopLimit, true)
+	} else {
+		c.p4 = ipv4.NewPacketConn(conn)
+		c.p4.SetControlMessage(ipv4.FlagTTL, true)
+	}
+	go c.run()
+	return c, nil
+}
+
+func (this *icmpConn) close() error {
+	return this.conn.Close()
+}
+
+func (this *icmpConn) closed() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.err != nil
+}
+
+// seq 为 IcmpPing 自己的序号，仅用于显示。
+// 特权模式下 ID 可以区分不同的 IcmpPing，直接使用该序号；
+// 非特权模式下 ID 由系统分配，所有请求共用一个 ID，因此改用连接内递增的序号
+func (this *icmpConn) send(owner *IcmpPing, id int, dst net.IP, seq int, data []byte, ttl int, df bool, tos int) (*icmpProbe, error) {
+	probe := &icmpProbe{
+		owner: owner,
+		dst:   dst,
+		seq:   seq,
+		data:  data,
+		reply: make(chan *IcmpPingResult, 1),
+
+		stamped: owner.Timestamp && len(data) >= icmpTimestampLen,
+	}
+
+	this.mu.Lock()
+	if this.err != nil {
+		this.mu.Unlock()
+		return nil, this.err
+	}
+	if this.network == "ip" {
+		probe.key = icmpKey{id, seq & 0xffff, dst.String()}
+	} else {
+		id = 0
+		probe.key = icmpKey{0, this.nextseq, dst.String()}
+		this.nextseq = (this.nextseq + 1) & 0xffff
+	}
+	this.probes[probe.key] = probe
+	this.sweep()
+	this.mu.Unlock()
+
+	msg, err := getmsg(this.isipv6, id, probe.key.seq, data).Marshal(nil)
+	if err != nil {
+		this.abort(probe)
+		return nil, err
+	}
+	var addr net.Addr = &net.IPAddr{IP: dst}
+	if this.network == "udp" {
+		addr = &net.UDPAddr{IP: dst}
+	}
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+	if err := this.setttl(ttl); err != nil {
+		this.abort(probe)
+		return nil, err
+	}
+	if err := this.setdf(df); err != nil {
+		this.abort(probe)
+		return nil, err
+	}
+	if err := this.settos(tos); err != nil {
+		this.abort(probe)
+		return nil, err
+	}
+	this.mu.Lock()
+	probe.sendAt = time.Now()
+	this.mu.Unlock()
+	for retried := false; ; {
+		if _, err := this.conn.WriteTo(msg, addr); err != nil {
+			if neterr, ok := err.(*net.OpError); ok {
+				if neterr.Err == syscall.ENOBUFS {
+					continue
+				}
+			}
+			// 设置了 DF，而包超过了本地已知的 MTU
+			if errors.Is(err, syscall.EMSGSIZE) {
+				this.abort(probe)
+				return nil, &FragmentationNeededError{MTU: this.localmtu()}
+			}
+			// 非特权模式下，之前收到的差错报文会在这次发送时以错误返回，取出后重试一次
+			var errno syscall.Errno
+			if this.network == "udp" && !retried && errors.As(err, &errno) {
+				retried = true
+				this.readerr()
+				continue
+			}
+			this.abort(probe)
+			return nil, err
+		}
+		break
+	}
+	return probe, nil
+}
+
+func (this *icmpConn) abort(probe *icmpProbe) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	probe.state = probeTimedout
+	probe.data = nil
+}
+
+func (this *icmpConn) setttl(ttl int) error {
+	if ttl <= 0 {
+		if this.ttl == 0 {
+			return nil
+		}
+		// 恢复系统默认值
+		ttl = this.defttl
+	}
+	if ttl == this.ttl {
+		return nil
+	}
+	var err error
+	if this.isipv6 {
+		if this.ttl == 0 {
+			this.defttl, err = this.p6.HopLimit()
+		}
+		if err == nil {
+			err = this.p6.SetHopLimit(ttl)
+		}
+	} else {
+		if this.ttl == 0 {
+			this.defttl, err = this.p4.TTL()
+		}
+		if err == nil {
+			err = this.p4.SetTTL(ttl)
+		}
+	}
+	if err == nil {
+		this.ttl = ttl
+	}
+	return err
+}
+
+func (this *icmpConn) setdf(df bool) error {
+	if df == this.df {
+		return nil
+	}
+	var operr error
+	err := this.raw.Control(func(fd uintptr) {
+		operr = setsockoptDF(fd, this.isipv6, df)
+	})
+	if err == nil {
+		err = operr
+	}
+	if err == nil {
+		this.df = df
+	}
+	return err
+}
+
+// 设置 IPv4 TOS 或 IPv6 Traffic Class，与当前值相同时跳过
+func (this *icmpConn) settos(tos int) error {
+	if tos < 0 {
+		tos = 0
+	}
+	if tos == this.tos {
+		return nil
+	}
+	var err error
+	if this.isipv6 {
+		err = this.p6.SetTrafficClass(tos)
+	} else {
+		err = this.p4.SetTOS(tos)
+	}
+	if err == nil {
+		this.tos = tos
+	}
+	return err
+}
+
+// 本地发送失败时的 MTU，仅非特权模式下可以从错误队列中得到，其他差错报文照常分发
+func (this *icmpConn) localmtu() int {
+	if this.network != "udp" {
+		return 0
+	}
+	mtu := 0
+	msgs, _ := readErrQueue(this.raw, this.isipv6)
+	recvAt := time.Now()
+	for _, msg := range msgs {
+		if msg.peer == nil && msg.msgtype == 4 {
+			mtu = msg.mtu
+			continue
+		}
+		this.dispatch(msg, recvAt)
+	}
+	return mtu
+}
+
+// 等待回复，直到超时或 ctx 结束
+func (this *icmpConn) wait(ctx context.Context, probe *icmpProbe, timeout time.Duration) *IcmpPingResult {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case r := <-probe.reply:
+		return r
+	case <-timer.C:
+		err = fmt.Errorf("%s: %w", probe.dst.String(), ErrTimeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	this.mu.Lock()
+	if probe.state == probePending {
+		probe.state = probeTimedout
+		this.mu.Unlock()
+		return &IcmpPingResult{Err: err, Seq: probe.seq}
+	}
+	this.mu.Unlock()
+	// 回复恰好在超时的同时到达
+	return <-probe.reply
+}
+
+// 清理早已完成的请求，调用者需持有锁
+func (this *icmpConn) sweep() {
+	if time.Since(this.lastsweep) < time.Second*10 {
+		return
+	}
+	this.lastsweep = time.Now()
+	for k, v := range this.probes {
+		if v.state != probePending && time.Since(v.sendAt) > time.Minute {
+			delete(this.probes, k)
+		}
+	}
+}
+
+func (this *icmpConn) run() {
+	// 直接分配一个足够大的缓冲区
+	recvBytes := make([]byte, 65536)
+	recvProto := 1
+	if this.isipv6 {
+		recvProto = 58
+	}
+	for {
+		ttl := -1
+		var peer net.Addr
+		var recvSize int
+		var err error
+		if this.isipv6 {
+			var cm *ipv6.ControlMessage
+			recvSize, cm, peer, err = this.p6.ReadFrom(recvBytes)
+			if cm != nil {
+				ttl = cm.HopLimit
+			}
+		} else {
+			var cm *ipv4.ControlMessage
+			recvSize, cm, peer, err = this.p4.ReadFrom(recvBytes)
+			if cm != nil {
+				ttl = cm.TTL
+			}
+		}
+		if err != nil {
+			// 非特权模式下，差错报文不会直接交给我们，而是放在错误队列中
+			var errno syscall.Errno
+			if this.network == "udp" && errors.As(err, &errno) && this.readerr() == nil {
+				continue
+			}
+			this.stop(err)
+			return
+		}
+		recvAt := time.Now()
+
+		recvMsg, err := icmp.ParseMessage(recvProto, recvBytes[:recvSize])
+		if err != nil {
+			continue
+		}
+		msg := parserecvmsg(this.isipv6, recvMsg, recvBytes[:recvSize])
+		switch addr := peer.(type) {
+		case *net.IPAddr:
+			msg.peer = addr.IP
+		case *net.UDPAddr:
+			msg.peer = addr.IP
+		}
+		msg.ttl = ttl
+		// echo 回复来自目标地址，差错报文的目标地址取自其中的原始 IP 包头
+		if msg.msgtype == 1 {
+			msg.dst = msg.peer
+		}
+		this.dispatch(msg, recvAt)
+	}
+}
+
+// 取出错误队列中的差错报文并分发
+func (this *icmpConn) readerr() error {
+	msgs, err := readErrQueue(this.raw, this.isipv6)
+	recvAt := time.Now()
+	for _, msg := range msgs {
+		this.dispatch(msg, recvAt)
+	}
+	return err
+}
+
+func (this *icmpConn) dispatch(msg *icmpMessage, recvAt time.Time) {
+	if msg.msgtype == 0 || msg.seq < 0 {
+		return
+	}
+	// 非特权模式下，ID 由系统分配，系统只会将属于当前连接的报文交给我们
+	if this.network == "udp" {
+		msg.id = 0
+	}
+
+	this.mu.Lock()
+	probe := this.probes[icmpKey{msg.id, msg.seq, msg.dst.String()}]
+	if probe == nil {
+		this.mu.Unlock()
+		return
+	}
+	state := probe.state
+	if state == probePending {
+		// 收到的数据和发送的数据不一致，不是对这个请求的回复
+		if msg.msgtype == 1 && !bytes.Equal(msg.data, probe.data) {
+			this.mu.Unlock()
+			return
+		}
+		if msg.msgtype != 1 && !bytes.HasPrefix(probe.data, msg.data[:min(len(msg.data), len(probe.data))]) {
+			this.mu.Unlock()
+			return
+		}
+		probe.state = probeReplied
+	} else if msg.msgtype != 1 || !bytes.Equal(msg.data, probe.data) {
+		// 重复及超时的回复同样需要核对数据，ID 可能与其他进程冲突
+		this.mu.Unlock()
+		return
+	}
+	this.mu.Unlock()
+
+	ip := probe.dst
+	if msg.peer != nil {
+		ip = msg.peer
+	}
+	result := &IcmpPingResult{
+		Seq:  probe.seq,
+		IP:   ip,
+		Time: int(recvAt.Sub(probe.sendAt).Milliseconds()),
+	}
+	if msg.msgtype == 1 {
+		result.TTL = msg.ttl
+		// 超时的请求不计算延迟，只能根据数据中的发送时间计算
+		if state == probeTimedout {
+			result.Time = -1
+			if probe.stamped {
+				result.Time = timestampRTT(msg.data, recvAt)
+			}
+		}
+	} else {
+		result.Err = icmpError(msg, ip)
+	}
+	if result.Err == nil {
+		probe.owner.onreply(result, state)
+	}
+	if state == probePending {
+		probe.reply <- result
+	}
+}
+
+// 连接关闭或出错，结束所有等待中的请求
+func (this *icmpConn) stop(err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.err = err
+	for _, probe := range this.probes {
+		if probe.state == probePending {
+			probe.state = probeReplied
+			probe.reply <- &IcmpPingResult{Err: err, Seq: probe.seq}
+		}
+	}
+}
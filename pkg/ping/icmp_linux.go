@@ -0,0 +1,127 @@
+//go:build linux
+
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+)
+
+const (
+	soEeOriginLocal = 1
+	soEeOriginIcmp  = 2
+	soEeOriginIcmp6 = 3
+)
+
+func dgramSockopt(s int, isipv6 bool) error {
+	// 通过错误队列接收差错报文
+	if isipv6 {
+		return syscall.SetsockoptInt(s, syscall.SOL_IPV6, syscall.IPV6_RECVERR, 1)
+	}
+	return syscall.SetsockoptInt(s, syscall.SOL_IP, syscall.IP_RECVERR, 1)
+}
+
+// 读取错误队列中的差错报文，数据部分为原始的 echo 请求
+func readErrQueue(raw syscall.RawConn, isipv6 bool) ([]*icmpMessage, error) {
+	var msgs []*icmpMessage
+	var operr error
+	// 不等待，使用 Control 以免与接收协程争用读锁
+	err := raw.Control(func(fd uintptr) {
+		msgs, operr = recvErrQueue(int(fd))
+	})
+	if err == nil {
+		err = operr
+	}
+	return msgs, err
+}
+
+func recvErrQueue(fd int) ([]*icmpMessage, error) {
+	var msgs []*icmpMessage
+	buf := make([]byte, 65536)
+	oob := make([]byte, 512)
+	for {
+		n, oobn, _, from, err := syscall.Recvmsg(fd, buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+		if err != nil {
+			if err == syscall.EAGAIN {
+				err = nil
+			}
+			return msgs, err
+		}
+		if msg := parseErrQueue(buf[:n], oob[:oobn], from); msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+}
+
+func parseErrQueue(b, oob []byte, from syscall.Sockaddr) *icmpMessage {
+	cmsgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, cmsg := range cmsgs {
+		if !(cmsg.Header.Level == syscall.SOL_IP && cmsg.Header.Type == syscall.IP_RECVERR) &&
+			!(cmsg.Header.Level == syscall.SOL_IPV6 && cmsg.Header.Type == syscall.IPV6_RECVERR) {
+			continue
+		}
+		// struct sock_extended_err，其后为 offender 地址
+		ee := cmsg.Data
+		if len(ee) < 16 {
+			continue
+		}
+		m := &icmpMessage{ttl: -1, id: -1, seq: -1}
+		// 本地产生的错误不包含原始请求
+		if len(b) >= 8 {
+			m.id = int(binary.BigEndian.Uint16(b[4:6]))
+			m.seq = int(binary.BigEndian.Uint16(b[6:8]))
+			m.data = append([]byte(nil), b[8:]...)
+		}
+		errno := syscall.Errno(binary.NativeEndian.Uint32(ee[0:4]))
+		origin, icmptype, code := ee[4], ee[5], ee[6]
+		info := int(binary.NativeEndian.Uint32(ee[8:12]))
+		switch {
+		case origin == soEeOriginIcmp && icmptype == 3 && code == 4, origin == soEeOriginIcmp6 && icmptype == 2:
+			m.msgtype = 4
+			m.mtu = info
+		case origin == soEeOriginLocal && errno == syscall.EMSGSIZE:
+			m.msgtype = 4
+			m.mtu = info
+		case origin == soEeOriginIcmp && icmptype == 3, origin == soEeOriginIcmp6 && icmptype == 1:
+			m.msgtype = 2
+		case origin == soEeOriginIcmp && icmptype == 11, origin == soEeOriginIcmp6 && icmptype == 3:
+			m.msgtype = 3
+		default:
+			continue
+		}
+		switch sa := from.(type) {
+		case *syscall.SockaddrInet4:
+			m.dst = net.IP(sa.Addr[:])
+		case *syscall.SockaddrInet6:
+			m.dst = net.IP(sa.Addr[:])
+		}
+		offender := ee[16:]
+		if len(offender) >= 8 && binary.NativeEndian.Uint16(offender) == syscall.AF_INET {
+			m.peer = net.IP(append([]byte(nil), offender[4:8]...))
+		} else if len(offender) >= 24 && binary.NativeEndian.Uint16(offender) == syscall.AF_INET6 {
+			m.peer = net.IP(append([]byte(nil), offender[8:24]...))
+		}
+		return m
+	}
+	return nil
+}
+
+func setsockoptDF(fd uintptr, isipv6 bool, df bool) error {
+	// 不设置 DF 时恢复系统默认的行为
+	if isipv6 {
+		v := syscall.IPV6_PMTUDISC_WANT
+		if df {
+			v = syscall.IPV6_PMTUDISC_DO
+		}
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, v)
+	}
+	v := syscall.IP_PMTUDISC_WANT
+	if df {
+		v = syscall.IP_PMTUDISC_DO
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, v)
+}
@@ -5,8 +5,25 @@ package ping
 import (
 	"context"
 	"errors"
+	"net"
+	"syscall"
 )
 
 func (this *IcmpPing) ping_rootless(ctx context.Context) IPingResult {
 	return this.errorResult(errors.New("not supported"))
 }
+
+func listenIcmpDgram(isipv6 bool) (net.PacketConn, error) {
+	return nil, errors.New("not supported")
+}
+
+func readErrQueue(raw syscall.RawConn, isipv6 bool) ([]*icmpMessage, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func setsockoptDF(fd uintptr, isipv6 bool, df bool) error {
+	if df {
+		return errors.ErrUnsupported
+	}
+	return nil
+}
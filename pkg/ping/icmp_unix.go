@@ -4,8 +4,35 @@ package ping
 
 import (
 	"context"
+	"net"
+	"os"
+	"syscall"
 )
 
 func (this *IcmpPing) ping_rootless(ctx context.Context) IPingResult {
-	return this.rawping("udp")
+	return this.rawping(ctx, "udp")
+}
+
+func listenIcmpDgram(isipv6 bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, 1
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if isipv6 {
+		family, proto = syscall.AF_INET6, 58
+		sa = &syscall.SockaddrInet6{}
+	}
+	s, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := dgramSockopt(s, isipv6); err != nil {
+		syscall.Close(s)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Bind(s, sa); err != nil {
+		syscall.Close(s)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(s), "datagram-oriented icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
 }
@@ -7,7 +7,6 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"syscall"
 	"time"
@@ -60,6 +59,24 @@ var (
 )
 
 func (this *IcmpPing) ping_rootless(ctx context.Context) IPingResult {
+	timeout := this.Timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	// IcmpSendEcho2 会一直阻塞到超时，放到协程中执行以便响应 ctx
+	c := make(chan IPingResult, 1)
+	go func() {
+		c <- this.sendecho(timeout)
+	}()
+	select {
+	case r := <-c:
+		return r
+	case <-ctx.Done():
+		return this.errorResult(ctx.Err())
+	}
+}
+
+func (this *IcmpPing) sendecho(timeout time.Duration) IPingResult {
 	ip, isipv6, err := this.parseip()
 	if err != nil {
 		return this.errorResult(err)
@@ -70,22 +87,24 @@ func (this *IcmpPing) ping_rootless(ctx context.Context) IPingResult {
 			IcmpCloseHandle(handle)
 		}
 	}()
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	if isipv6 {
 		handle = Icmp6CreateFile()
 		if handle == syscall.InvalidHandle {
 			return this.errorResult(errors.New("IcmpCreateFile failed"))
 		}
-		data := make([]byte, this.Size)
-		r.Read(data)
-		recv := Icmp6SendEcho(handle, ip, data, this.Timeout, this.TTL)
+		data := this.payload()
+		recv, err := Icmp6SendEcho(handle, ip, data, timeout, this.TTL, this.DontFragment, this.TOS)
 		if recv == nil {
-			return this.errorResult(errors.New("IcmpSendEcho failed"))
+			return this.errorResult(icmpSendEchoError(ip, err))
 		}
 		recvmsg := (*icmpv6_echo_reply)(unsafe.Pointer(&recv[0]))
 		var ip net.IP = recvmsg.address.data[6:22]
 		if recvmsg.status != 0 {
-			return this.errorResult(fmt.Errorf("%s: %s", ip.String(), icmpStatusToString(recvmsg.status)))
+			return &IcmpPingResult{
+				Time: int(recvmsg.roundtriptime),
+				Err:  icmpStatusToError(ip, recvmsg.status),
+				IP:   ip,
+			}
 		}
 		return &IcmpPingResult{
 			Time: int(recvmsg.roundtriptime),
@@ -97,16 +116,19 @@ func (this *IcmpPing) ping_rootless(ctx context.Context) IPingResult {
 		if handle == syscall.InvalidHandle {
 			return this.errorResult(errors.New("IcmpCreateFile failed"))
 		}
-		data := make([]byte, this.Size)
-		r.Read(data)
-		recv := IcmpSendEcho(handle, ip, data, this.Timeout, this.TTL)
+		data := this.payload()
+		recv, err := IcmpSendEcho(handle, ip, data, timeout, this.TTL, this.DontFragment, this.TOS)
 		if recv == nil {
-			return this.errorResult(errors.New("IcmpSendEcho failed"))
+			return this.errorResult(icmpSendEchoError(ip, err))
 		}
 		recvmsg := (*icmp_echo_reply)(unsafe.Pointer(&recv[0]))
 		var ip net.IP = recvmsg.address[:]
 		if recvmsg.status != 0 {
-			return this.errorResult(fmt.Errorf("%s: %s", ip.String(), icmpStatusToString(recvmsg.status)))
+			return &IcmpPingResult{
+				Time: int(recvmsg.roundtriptime),
+				Err:  icmpStatusToError(ip, recvmsg.status),
+				IP:   ip,
+			}
 		}
 		return &IcmpPingResult{
 			Time: int(recvmsg.roundtriptime),
@@ -116,6 +138,26 @@ func (this *IcmpPing) ping_rootless(ctx context.Context) IPingResult {
 	}
 }
 
+const ipFlagDF = 0x2
+
+func newIpOptionInformation(ttl int, df bool, tos int) *ip_option_information {
+	if ttl <= 0 && !df && tos <= 0 {
+		return nil
+	}
+	if ttl <= 0 {
+		// 系统默认值
+		ttl = 128
+	}
+	o := &ip_option_information{
+		ttl: uint8(ttl),
+		tos: uint8(max(tos, 0)),
+	}
+	if df {
+		o.flags = ipFlagDF
+	}
+	return o
+}
+
 func ipv4ToInt(ip net.IP) uint32 {
 	return binary.LittleEndian.Uint32(ip.To4())
 }
@@ -130,15 +172,10 @@ func IcmpCloseHandle(h syscall.Handle) uintptr {
 	return ret
 }
 
-func IcmpSendEcho(handle syscall.Handle, ip net.IP, data []byte, timeout time.Duration, ttl int) []byte {
+func IcmpSendEcho(handle syscall.Handle, ip net.IP, data []byte, timeout time.Duration, ttl int, df bool, tos int) ([]byte, error) {
 	buf := make([]byte, (int)(unsafe.Sizeof(icmp_echo_reply{}))+len(data))
-	var pOptions *ip_option_information
-	if ttl > 0 {
-		pOptions = &ip_option_information{
-			ttl: uint8(ttl),
-		}
-	}
-	n, _, _ := icmpSendEcho2.Call(
+	pOptions := newIpOptionInformation(ttl, df, tos)
+	n, _, err := icmpSendEcho2.Call(
 		uintptr(handle),                   // icmphandle
 		0,                                 // event
 		0,                                 // apcroutine
@@ -152,9 +189,9 @@ func IcmpSendEcho(handle syscall.Handle, ip net.IP, data []byte, timeout time.Du
 		uintptr(timeout.Milliseconds()),   // timeout
 	)
 	if n == 0 {
-		return nil
+		return nil, err
 	}
-	return buf
+	return buf, nil
 }
 
 func Icmp6CreateFile() syscall.Handle {
@@ -162,7 +199,7 @@ func Icmp6CreateFile() syscall.Handle {
 	return syscall.Handle(h)
 }
 
-func Icmp6SendEcho(handle syscall.Handle, ip net.IP, data []byte, timeout time.Duration, ttl int) []byte {
+func Icmp6SendEcho(handle syscall.Handle, ip net.IP, data []byte, timeout time.Duration, ttl int, df bool, tos int) ([]byte, error) {
 	ip6source := syscall.RawSockaddrInet6{
 		Family: syscall.AF_INET6,
 	}
@@ -171,13 +208,8 @@ func Icmp6SendEcho(handle syscall.Handle, ip net.IP, data []byte, timeout time.D
 	}
 	copy(ip6dest.Addr[:], ip)
 	buf := make([]byte, (int)(unsafe.Sizeof(icmpv6_echo_reply{}))+len(data))
-	var pOptions *ip_option_information
-	if ttl > 0 {
-		pOptions = &ip_option_information{
-			ttl: uint8(ttl),
-		}
-	}
-	n, _, _ := icmp6SendEcho2.Call(
+	pOptions := newIpOptionInformation(ttl, df, tos)
+	n, _, err := icmp6SendEcho2.Call(
 		uintptr(handle),                     // icmphandle
 		0,                                   // event
 		0,                                   // apcroutine
@@ -192,9 +224,31 @@ func Icmp6SendEcho(handle syscall.Handle, ip net.IP, data []byte, timeout time.D
 		uintptr(timeout.Milliseconds()),     // timeout
 	)
 	if n == 0 {
-		return nil
+		return nil, err
 	}
-	return buf
+	return buf, nil
+}
+
+// 超时等情况下 IcmpSendEcho2 不返回任何回复，错误码由 GetLastError 给出
+func icmpSendEchoError(ip net.IP, err error) error {
+	if errno, ok := err.(syscall.Errno); ok && errno != 0 {
+		return icmpStatusToError(ip, uint32(errno))
+	}
+	return errors.New("IcmpSendEcho failed")
+}
+
+func icmpStatusToError(ip net.IP, status uint32) error {
+	switch status {
+	case 11002, 11003, 11004, 11005:
+		return fmt.Errorf("%s: %w (%s)", ip.String(), ErrDestinationUnreachable, icmpStatusToString(status))
+	case 11009:
+		return &FragmentationNeededError{IP: ip}
+	case 11010:
+		return fmt.Errorf("%s: %w", ip.String(), ErrTimeout)
+	case 11013:
+		return fmt.Errorf("%s: %w", ip.String(), ErrTimeExceeded)
+	}
+	return fmt.Errorf("%s: %s", ip.String(), icmpStatusToString(status))
 }
 
 func icmpStatusToString(status uint32) string {
@@ -203,6 +257,12 @@ func icmpStatusToString(status uint32) string {
 		return "destination network was unreachable"
 	case 11003:
 		return "destination host was unreachable"
+	case 11004:
+		return "destination protocol was unreachable"
+	case 11005:
+		return "destination port was unreachable"
+	case 11009:
+		return "packet too big"
 	case 11010:
 		return "request timed out"
 	case 11013:
@@ -210,3 +270,27 @@ func icmpStatusToString(status uint32) string {
 	}
 	return fmt.Sprintf("unknown error (%d)", status)
 }
+
+func listenIcmpDgram(isipv6 bool) (net.PacketConn, error) {
+	return nil, errors.New("not supported")
+}
+
+func readErrQueue(raw syscall.RawConn, isipv6 bool) ([]*icmpMessage, error) {
+	return nil, errors.ErrUnsupported
+}
+
+const (
+	sysIP_DONTFRAGMENT = 14
+	sysIPV6_DONTFRAG   = 14
+)
+
+func setsockoptDF(fd uintptr, isipv6 bool, df bool) error {
+	v := 0
+	if df {
+		v = 1
+	}
+	if isipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, sysIPV6_DONTFRAG, v)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, sysIP_DONTFRAGMENT, v)
+}
@@ -0,0 +1,160 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 路径 MTU 探测中的一次请求
+type MtuProbe struct {
+	MTU    int
+	Size   int
+	Result *IcmpPingResult
+}
+
+func (this *MtuProbe) OK() bool {
+	return this.Result.Err == nil
+}
+
+func (this *MtuProbe) String() string {
+	return fmt.Sprintf("mtu=%d, size=%d: %v", this.MTU, this.Size, this.Result)
+}
+
+// 设置 DF 标志发送不同大小的 ICMP echo 请求，二分查找能收到回复的最大包，
+// 用于发现丢弃大包而不回复“需要分片”的 MTU 黑洞
+type IcmpMtu struct {
+	host    string
+	Timeout time.Duration
+
+	Privileged bool
+	// 查找范围，为 0 时 IPv4 为 68 ~ 1500，IPv6 为 1280 ~ 1500
+	MinMTU int
+	MaxMTU int
+	// 每个大小的请求次数，任意一次收到回复即认为可以通过
+	Retries int
+}
+
+func (this *IcmpMtu) SetHost(host string) {
+	this.host = host
+}
+
+func (this *IcmpMtu) Host() string {
+	return this.host
+}
+
+func NewIcmpMtu(host string, timeout time.Duration) *IcmpMtu {
+	return &IcmpMtu{
+		host:    host,
+		Timeout: timeout,
+		Retries: 2,
+	}
+}
+
+func (this *IcmpMtu) Discover(f func(*MtuProbe)) (int, error) {
+	return this.DiscoverContext(context.Background(), f)
+}
+
+// 每次请求后调用 f，返回路径 MTU
+func (this *IcmpMtu) DiscoverContext(ctx context.Context, f func(*MtuProbe)) (int, error) {
+	p := NewIcmpPing(this.host, this.Timeout)
+	ip, isipv6, err := p.parseip()
+	if err != nil {
+		return 0, err
+	}
+	p.SetHost(ip.String())
+	p.Privileged = this.Privileged
+	p.DontFragment = true
+	defer p.Close()
+
+	// IP 头部及 ICMP 头部
+	hdrlen, lo, hi := 28, 68, 1500
+	if isipv6 {
+		hdrlen, lo = 48, 1280
+	}
+	if this.MinMTU > 0 {
+		lo = this.MinMTU
+	}
+	if this.MaxMTU > 0 {
+		hi = this.MaxMTU
+	}
+	if lo > hi {
+		return 0, errors.New("invalid mtu range")
+	}
+
+	// 返回是否通过，及路由器通告的 MTU
+	probe := func(mtu int) (bool, int, error) {
+		p.Size = mtu - hdrlen
+		for i := 0; i < max(this.Retries, 1); i++ {
+			r := p.PingContext(ctx).(*IcmpPingResult)
+			if err := ctx.Err(); err != nil {
+				return false, 0, err
+			}
+			f(&MtuProbe{MTU: mtu, Size: p.Size, Result: r})
+			if r.Err == nil {
+				return true, 0, nil
+			}
+			var fe *FragmentationNeededError
+			if errors.As(r.Err, &fe) {
+				return false, fe.MTU, nil
+			}
+			if !errors.Is(r.Err, ErrTimeout) {
+				return false, 0, r.Err
+			}
+		}
+		return false, 0, nil
+	}
+
+	mtu, err := searchMtu(lo, hi, probe)
+	if err != nil {
+		return 0, err
+	}
+	if mtu == 0 {
+		return 0, fmt.Errorf("%s: no reply with mtu %d", ip.String(), lo)
+	}
+	return mtu, nil
+}
+
+// 在 lo ~ hi 之间二分查找能通过的最大 MTU，probe 返回是否通过，及路由器通告的 MTU。
+// lo 也不能通过时返回 0
+func searchMtu(lo, hi int, probe func(mtu int) (bool, int, error)) (int, error) {
+	ok, _, err := probe(lo)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
+	}
+	// lo 为已知可以通过的大小，hi 为已知不能通过的大小
+	ok, next, err := probe(hi)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return hi, nil
+	}
+	for {
+		// 大于路由器通告的 MTU 的包都不能通过
+		if next >= lo && next < hi {
+			hi = next + 1
+		}
+		if hi-lo <= 1 {
+			return lo, nil
+		}
+		mid := (lo + hi) / 2
+		// 优先尝试路由器通告的 MTU
+		if next > lo && next < hi {
+			mid = next
+		}
+		ok, next, err = probe(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+}
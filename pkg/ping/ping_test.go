@@ -0,0 +1,220 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseClientSubnet(t *testing.T) {
+	tests := []struct {
+		s      string
+		family uint16
+		bits   uint8
+		addr   string
+	}{
+		{"1.2.3.4", 1, 32, "1.2.3.4"},
+		{"1.2.3.4/24", 1, 24, "1.2.3.0"},
+		{"0.0.0.0/0", 1, 0, "0.0.0.0"},
+		{"2001:db8::1", 2, 128, "2001:db8::1"},
+		{"2001:db8::1/56", 2, 56, "2001:db8::"},
+		{"::/0", 2, 0, "::"},
+	}
+	for _, tt := range tests {
+		ecs, err := parseClientSubnet(tt.s)
+		if err != nil {
+			t.Fatal(tt.s, err)
+		}
+		if ecs.Family != tt.family || ecs.SourceNetmask != tt.bits || ecs.Address.String() != tt.addr {
+			t.Fatal(tt.s, ecs)
+		}
+	}
+	for _, s := range []string{"", "1.2.3", "1.2.3.4/33"} {
+		if _, err := parseClientSubnet(s); err == nil {
+			t.Fatal(s)
+		}
+	}
+}
+
+func TestDnsCheck(t *testing.T) {
+	a, _ := dns.NewRR("example.com. 60 IN A 192.0.2.1")
+	mx, _ := dns.NewRR("example.com. 60 IN MX 10 mail.example.com.")
+	r := &DnsPingResult{Answer: []dns.RR{a, mx}}
+	if s := answerString(r.Answer); s != "A 192.0.2.1,MX 10 mail.example.com." {
+		t.Fatal(s)
+	}
+	p := NewDnsPing("127.0.0.1", time.Second)
+	p.ExpectIP = net.ParseIP("192.0.2.1")
+	if err := p.check(r); err != nil {
+		t.Fatal(err)
+	}
+	p.ExpectIP = net.ParseIP("192.0.2.2")
+	if err := p.check(r); err == nil {
+		t.Fatal("unexpected match")
+	}
+	p.ExpectIP = nil
+	p.ExpectAnswer = regexp.MustCompile(`^10 mail\.`)
+	if err := p.check(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSerialLess(t *testing.T) {
+	tests := []struct {
+		a, b uint32
+		less bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{5, 5, false},
+		{0xffffffff, 0, true},
+		{0xfffffff0, 10, true},
+		{10, 0xfffffff0, false},
+	}
+	for _, tt := range tests {
+		if serialLess(tt.a, tt.b) != tt.less {
+			t.Fatal(tt.a, tt.b)
+		}
+	}
+}
+
+func TestPortState(t *testing.T) {
+	tests := []struct {
+		err   error
+		state PortState
+	}{
+		{nil, PortOpen},
+		{fmt.Errorf("1.2.3.4: %w", ErrTimeout), PortFiltered},
+		{&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, PortFiltered},
+		{&net.OpError{Op: "dial", Err: errors.New("connect: no route to host")}, PortError},
+	}
+	for _, tt := range tests {
+		r := &TcpPingResult{Err: tt.err}
+		if r.State() != tt.state {
+			t.Fatal(tt.err, r.State())
+		}
+	}
+}
+
+func TestSearchMtu(t *testing.T) {
+	tests := []struct {
+		lo, hi, path int
+		// 路由器是否通告 MTU，否则为黑洞
+		advertise bool
+		want      int
+		maxprobes int
+	}{
+		{68, 1500, 1500, false, 1500, 2},
+		{68, 1500, 1400, false, 1400, 13},
+		{68, 1500, 1400, true, 1400, 3},
+		{1280, 1500, 1280, false, 1280, 10},
+		{68, 1500, 1499, true, 1499, 3},
+		{1280, 1500, 1000, false, 0, 1},
+	}
+	for _, tt := range tests {
+		probes := 0
+		mtu, err := searchMtu(tt.lo, tt.hi, func(mtu int) (bool, int, error) {
+			probes++
+			if mtu <= tt.path {
+				return true, 0, nil
+			}
+			if tt.advertise {
+				return false, tt.path, nil
+			}
+			return false, 0, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mtu != tt.want || probes > tt.maxprobes {
+			t.Fatal(tt, mtu, probes)
+		}
+	}
+	if _, err := searchMtu(68, 1500, func(mtu int) (bool, int, error) {
+		return false, 0, errors.New("send failed")
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestNtpTime(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		want uint64
+	}{
+		{time.Unix(0, 0), 2208988800 << 32},
+		{time.Unix(1, 500000000), (2208988801 << 32) | 0x80000000},
+		{time.Unix(1700000000, 250000000), ((1700000000 + 2208988800) << 32) | 0x40000000},
+	}
+	for _, tt := range tests {
+		if v := ntpTime(tt.t); v != tt.want {
+			t.Fatalf("%v: %#x", tt.t, v)
+		}
+		if back := fromNtpTime(tt.want); !back.Equal(tt.t) {
+			t.Fatal(tt.t, back)
+		}
+	}
+	// 往返转换的误差小于 1 ns
+	now := time.Now()
+	if d := fromNtpTime(ntpTime(now)).Sub(now); d > 0 || d < -time.Nanosecond {
+		t.Fatal(d)
+	}
+}
+
+func TestStampReorder(t *testing.T) {
+	p := NewStampPing("127.0.0.1", time.Second)
+	p.reflected = make(map[int]int)
+	// 反射端序号 1 的回复晚于 2 到达，发送序号 3 先于 2 到达反射端，2 的回复重复
+	for _, r := range [][2]int{{0, 0}, {2, 2}, {1, 3}, {2, 2}, {3, 4}} {
+		p.record(r[0], r[1])
+	}
+	p.stats.Sent = 5
+	stats := p.Statistics()
+	if stats.Received != 4 || stats.ReverseReordered != 1 || stats.ForwardReordered != 1 || stats.ForwardLoss != 1 || stats.ReverseLoss != 0 {
+		t.Fatal(stats)
+	}
+	if len(p.reflected) != 0 {
+		t.Fatal(p.reflected)
+	}
+
+	// 缺少的回复超出窗口后不再等待
+	p.record(5, 6)
+	p.record(6+stampReorderWindow, 7)
+	if len(p.reflected) != 0 || p.nextrefl != 7+stampReorderWindow {
+		t.Fatal(p.nextrefl, p.reflected)
+	}
+	if p.record(4, 5) {
+		t.Fatal("late reply accepted")
+	}
+}
+
+func TestParseAltSvc(t *testing.T) {
+	tests := []struct {
+		v      string
+		port   string
+		maxAge time.Duration
+		clear  bool
+	}{
+		{`h3=":443"; ma=86400`, "443", 86400 * time.Second, false},
+		{`h3=":8443"`, "8443", 24 * time.Hour, false},
+		{`h3-29=":443"; ma=60, h3="alt.example.com:4433"; ma=120; persist=1`, "4433", 120 * time.Second, false},
+		{`h2=":443"; ma=60`, "", 0, false},
+		{`h3=":443"; ma="30"`, "443", 30 * time.Second, false},
+		{`h3=":443"; ma=bad`, "443", 24 * time.Hour, false},
+		{`h3="noport"`, "", 0, false},
+		{` clear `, "", 0, true},
+		{``, "", 0, false},
+	}
+	for _, tt := range tests {
+		port, maxAge, clear := parseAltSvc(tt.v)
+		if port != tt.port || maxAge != tt.maxAge || clear != tt.clear {
+			t.Fatal(tt.v, port, maxAge, clear)
+		}
+	}
+}
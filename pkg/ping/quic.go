@@ -44,6 +44,7 @@ type QuicPing struct {
 	Insecure bool
 	ALPN     string
 	IP       net.IP
+	TOS      int
 }
 
 func (this *QuicPing) Ping() IPingResult {
@@ -73,8 +74,19 @@ func (this *QuicPing) PingContext(ctx context.Context) IPingResult {
 	quicconf := quic.Config{
 		HandshakeIdleTimeout: this.Timeout,
 	}
+	var conn quic.Connection
+	var err error
 	t0 := time.Now()
-	conn, err := quic.DialAddr(ctx, addr, &tlsconf, &quicconf)
+	if this.TOS > 0 {
+		udpconn, udpaddr, lerr := listenUDPTOS(addr, this.TOS)
+		if lerr != nil {
+			return this.errorResult(lerr)
+		}
+		defer udpconn.Close()
+		conn, err = quic.Dial(ctx, udpconn, udpaddr, &tlsconf, &quicconf)
+	} else {
+		conn, err = quic.DialAddr(ctx, addr, &tlsconf, &quicconf)
+	}
 	if err != nil {
 		return this.errorResult(err)
 	}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package ping
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func setsockoptTTL(fd uintptr, isipv6 bool, ttl int) error {
+	if isipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// 对方回复了 RST
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// 已连接的 UDP 套接字收到了 ICMP 端口不可达
+func isPortUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// 系统默认会报告端口不可达
+func reportPortUnreachable(conn *net.UDPConn) error {
+	return nil
+}
+
+func setsockoptTOS(fd uintptr, isipv6 bool, tos int) error {
+	if isipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
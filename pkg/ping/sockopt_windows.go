@@ -0,0 +1,62 @@
+//go:build windows
+
+package ping
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	wsaeconnrefused syscall.Errno = 10061
+	wsaeconnreset   syscall.Errno = 10054
+
+	sioUDPConnReset = syscall.IOC_IN | syscall.IOC_VENDOR | 12
+
+	sysIPV6_TCLASS = 39
+)
+
+func setsockoptTTL(fd uintptr, isipv6 bool, ttl int) error {
+	if isipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// 对方回复了 RST
+func isConnRefused(err error) bool {
+	return errors.Is(err, wsaeconnrefused)
+}
+
+// 已连接的 UDP 套接字收到了 ICMP 端口不可达
+func isPortUnreachable(err error) bool {
+	return errors.Is(err, wsaeconnreset)
+}
+
+// go 默认关闭了 SIO_UDP_CONNRESET，不会报告端口不可达，需要重新打开
+func reportPortUnreachable(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var operr error
+	err = raw.Control(func(fd uintptr) {
+		flag := uint32(1)
+		var ret uint32
+		operr = syscall.WSAIoctl(syscall.Handle(fd), sioUDPConnReset, (*byte)(unsafe.Pointer(&flag)), uint32(unsafe.Sizeof(flag)), nil, 0, &ret, nil, 0)
+	})
+	if err != nil {
+		return err
+	}
+	return operr
+}
+
+// 系统默认会忽略应用设置的 IP_TOS，需要修改组策略或注册表
+func setsockoptTOS(fd uintptr, isipv6 bool, tos int) error {
+	if isipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, sysIPV6_TCLASS, tos)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
@@ -0,0 +1,508 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// STAMP（RFC 8762）的无认证模式，反射端为有状态模式，按会话独立计数，
+// 客户端据此区分去程和回程的丢包
+
+const (
+	StampPort = 862
+
+	stampPacketLen = 44
+	// 1970 年与 1900 年（NTP 纪元）相差的秒数
+	ntpEpochOffset = 2208988800
+	// 时钟未同步，误差约 1 ms（Scale=22，Multiplier=1）
+	stampErrorEstimate = 0x1601
+	// 反射端会话的空闲时间，超过后重新计数
+	stampSessionIdle = time.Minute * 5
+)
+
+func ntpTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNtpTime(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nsec := (v & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(secs, int64(nsec))
+}
+
+type StampPingResult struct {
+	// 往返时间，不包括反射端的处理时间
+	Time int
+	// 去程和回程的单向延迟，两端时钟不同步时包含时钟偏差
+	Forward time.Duration
+	Reverse time.Duration
+	Seq     int
+	// 反射端的序号，即反射端在该会话中收到的第几个包
+	ReflectorSeq int
+	// 请求到达反射端时的 TTL
+	TTL int
+	Err error
+	IP  net.IP
+}
+
+func (this *StampPingResult) Result() int {
+	return this.Time
+}
+
+func (this *StampPingResult) Error() error {
+	return this.Err
+}
+
+func (this *StampPingResult) String() string {
+	if this.Err != nil {
+		return fmt.Sprintf("%s", this.Err)
+	} else {
+		return fmt.Sprintf("%s: seq=%d, time=%d ms, forward=%.3f ms, reverse=%.3f ms, TTL=%d", this.IP.String(), this.Seq, this.Time, durationToMs(this.Forward), durationToMs(this.Reverse), this.TTL)
+	}
+}
+
+func durationToMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+type StampStatistics struct {
+	Sent     int
+	Received int
+	// 根据反射端的序号计算，最后几个包的丢失无法区分方向，计入去程
+	ForwardLoss int
+	ReverseLoss int
+	// 到达反射端的顺序与发送顺序不一致
+	ForwardReordered int
+	// 回复到达的顺序与反射端发送的顺序不一致
+	ReverseReordered int
+}
+
+type stampProbe struct {
+	sendAt time.Time
+	reply  chan *StampPingResult
+}
+
+// STAMP 会话发送端，多次 ping 使用同一连接，即反射端的同一会话
+type StampPing struct {
+	host    string
+	Port    uint16
+	Timeout time.Duration
+
+	TOS int
+
+	mu      sync.Mutex
+	ip      net.IP
+	conn    *net.UDPConn
+	connip  net.IP
+	seq     uint32
+	probes  map[uint32]*stampProbe
+	stats   StampStatistics
+	maxrefl int
+	// 尚未计入去程乱序的回复，反射端序号到发送序号。
+	// nextrefl 之前的回复均已计入，maxseq 为其中最大的发送序号
+	reflected map[int]int
+	nextrefl  int
+	maxseq    int
+}
+
+// 等待乱序回复的最大反射端序号跨度，超出时缺少的回复视为丢失
+const stampReorderWindow = 1024
+
+func (this *StampPing) SetHost(host string) {
+	this.host = host
+	this.ip = net.ParseIP(host)
+}
+
+func (this *StampPing) Host() string {
+	return this.host
+}
+
+func NewStampPing(host string, timeout time.Duration) *StampPing {
+	p := &StampPing{
+		Port:    StampPort,
+		Timeout: timeout,
+		maxrefl: -1,
+		maxseq:  -1,
+	}
+	p.SetHost(host)
+	return p
+}
+
+func (this *StampPing) Ping() IPingResult {
+	return this.PingContext(context.Background())
+}
+
+func (this *StampPing) PingContext(ctx context.Context) IPingResult {
+	ip := cloneIP(this.ip)
+	if ip == nil {
+		var err error
+		ip, err = LookupFunc(this.host)
+		if err != nil {
+			return this.errorResult(err)
+		}
+	}
+	conn, err := this.getconn(ip)
+	if err != nil {
+		return this.errorResult(err)
+	}
+
+	probe := &stampProbe{reply: make(chan *StampPingResult, 1)}
+	this.mu.Lock()
+	seq := this.seq
+	this.seq++
+	this.probes[seq] = probe
+	this.stats.Sent++
+	this.mu.Unlock()
+	defer func() {
+		this.mu.Lock()
+		delete(this.probes, seq)
+		this.mu.Unlock()
+	}()
+
+	b := make([]byte, stampPacketLen)
+	binary.BigEndian.PutUint32(b[0:4], seq)
+	this.mu.Lock()
+	probe.sendAt = time.Now()
+	this.mu.Unlock()
+	binary.BigEndian.PutUint64(b[4:12], ntpTime(probe.sendAt))
+	binary.BigEndian.PutUint16(b[12:14], stampErrorEstimate)
+	if _, err := conn.Write(b); err != nil {
+		return this.errorResult(err)
+	}
+
+	timer := time.NewTimer(this.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-probe.reply:
+		if r.Err == nil {
+			r.IP = ip
+		}
+		return r
+	case <-timer.C:
+		return this.errorResult(fmt.Errorf("%s: %w", ip.String(), ErrTimeout))
+	case <-ctx.Done():
+		return this.errorResult(ctx.Err())
+	}
+}
+
+// 目标地址不变时复用连接
+func (this *StampPing) getconn(ip net.IP) (*net.UDPConn, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.conn != nil && this.connip.Equal(ip) {
+		return this.conn, nil
+	}
+	if this.conn != nil {
+		this.conn.Close()
+		this.conn = nil
+	}
+	dialer := &net.Dialer{
+		Control: tosControl(this.TOS),
+	}
+	c, err := dialer.Dial("udp", net.JoinHostPort(ip.String(), strconv.FormatUint(uint64(this.Port), 10)))
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*net.UDPConn)
+	if err := reportPortUnreachable(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	this.conn = conn
+	this.connip = ip
+	this.probes = make(map[uint32]*stampProbe)
+	this.reflected = make(map[int]int)
+	this.nextrefl = 0
+	this.maxseq = -1
+	this.maxrefl = -1
+	this.stats = StampStatistics{}
+	go this.recv(conn, ip)
+	return conn, nil
+}
+
+func (this *StampPing) recv(conn *net.UDPConn, ip net.IP) {
+	buf := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buf)
+		recvAt := time.Now()
+		if err != nil {
+			if isPortUnreachable(err) {
+				this.fail(fmt.Errorf("%s: %w", ip.String(), ErrPortClosed))
+				continue
+			}
+			return
+		}
+		if n < stampPacketLen {
+			continue
+		}
+		b := buf[:n]
+		reflseq := int(binary.BigEndian.Uint32(b[0:4]))
+		t3 := fromNtpTime(binary.BigEndian.Uint64(b[4:12]))
+		t2 := fromNtpTime(binary.BigEndian.Uint64(b[16:24]))
+		seq := binary.BigEndian.Uint32(b[24:28])
+		t1 := fromNtpTime(binary.BigEndian.Uint64(b[28:36]))
+		ttl := int(b[40])
+
+		this.mu.Lock()
+		if !this.record(reflseq, int(seq)) {
+			this.mu.Unlock()
+			continue
+		}
+		probe := this.probes[seq]
+		if probe == nil {
+			this.mu.Unlock()
+			continue
+		}
+		delete(this.probes, seq)
+		rtt := recvAt.Sub(probe.sendAt) - t3.Sub(t2)
+		this.mu.Unlock()
+		probe.reply <- &StampPingResult{
+			Time:         int(rtt.Milliseconds()),
+			Forward:      t2.Sub(t1),
+			Reverse:      recvAt.Sub(t3),
+			Seq:          int(seq),
+			ReflectorSeq: reflseq,
+			TTL:          ttl,
+		}
+	}
+}
+
+// 统计收到的回复，重复的回复或超出窗口的迟到回复返回 false
+func (this *StampPing) record(reflseq, seq int) bool {
+	if _, ok := this.reflected[reflseq]; ok || reflseq < this.nextrefl {
+		return false
+	}
+	this.stats.Received++
+	this.reflected[reflseq] = seq
+	if reflseq < this.maxrefl {
+		this.stats.ReverseReordered++
+	} else {
+		this.maxrefl = reflseq
+	}
+	this.fold()
+	return true
+}
+
+// 按反射端序号顺序将回复计入去程乱序，遇到缺少的回复时等待，直到超出窗口
+func (this *StampPing) fold() {
+	for len(this.reflected) > 0 {
+		seq, ok := this.reflected[this.nextrefl]
+		if !ok {
+			if this.maxrefl-this.nextrefl < stampReorderWindow {
+				return
+			}
+			// 跳过丢失的回复
+			this.nextrefl = slices.Min(slices.Collect(maps.Keys(this.reflected)))
+			continue
+		}
+		delete(this.reflected, this.nextrefl)
+		this.nextrefl++
+		if seq < this.maxseq {
+			this.stats.ForwardReordered++
+		} else {
+			this.maxseq = seq
+		}
+	}
+}
+
+// 结束所有等待中的请求
+func (this *StampPing) fail(err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for seq, probe := range this.probes {
+		delete(this.probes, seq)
+		probe.reply <- &StampPingResult{Err: err}
+	}
+}
+
+func (this *StampPing) Statistics() StampStatistics {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	stats := this.stats
+	// 反射端收到的包数
+	reflected := this.maxrefl + 1
+	stats.ForwardLoss = max(stats.Sent-reflected, 0)
+	stats.ReverseLoss = max(reflected-stats.Received, 0)
+	// 计入仍在等待的回复
+	keys := slices.Sorted(maps.Keys(this.reflected))
+	maxseq := this.maxseq
+	for _, k := range keys {
+		if seq := this.reflected[k]; seq < maxseq {
+			stats.ForwardReordered++
+		} else {
+			maxseq = seq
+		}
+	}
+	return stats
+}
+
+func (this *StampPing) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.conn == nil {
+		return nil
+	}
+	err := this.conn.Close()
+	this.conn = nil
+	return err
+}
+
+func (this *StampPing) errorResult(err error) *StampPingResult {
+	r := &StampPingResult{}
+	r.Err = err
+	return r
+}
+
+type stampSession struct {
+	seq  uint32
+	last time.Time
+}
+
+// STAMP 反射端，将收到的包加上时间戳后发回
+type StampReflector struct {
+	Addr string
+
+	// 每个新会话开始时调用
+	OnSession func(addr net.Addr)
+
+	mu        sync.Mutex
+	conn      *net.UDPConn
+	sessions  map[string]*stampSession
+	lastsweep time.Time
+}
+
+func NewStampReflector(addr string) *StampReflector {
+	return &StampReflector{Addr: addr}
+}
+
+// 开始监听，之后可以通过 LocalAddr 获取实际监听的地址
+func (this *StampReflector) Listen() error {
+	c, err := net.ListenPacket("udp", this.Addr)
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.conn = c.(*net.UDPConn)
+	this.sessions = make(map[string]*stampSession)
+	this.lastsweep = time.Now()
+	return nil
+}
+
+// 持续运行直到 ctx 结束或出错，未调用 Listen 时自动监听
+func (this *StampReflector) Serve(ctx context.Context) error {
+	this.mu.Lock()
+	conn := this.conn
+	this.mu.Unlock()
+	if conn == nil {
+		if err := this.Listen(); err != nil {
+			return err
+		}
+		conn = this.conn
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	// 获取请求的 TTL，不支持时为 0
+	ipv4.NewPacketConn(conn).SetControlMessage(ipv4.FlagTTL, true)
+	ipv6.NewPacketConn(conn).SetControlMessage(ipv6.FlagHopLimit, true)
+
+	buf := make([]byte, 65536)
+	oob := make([]byte, 128)
+	for {
+		n, oobn, _, addr, err := conn.ReadMsgUDP(buf, oob)
+		recvAt := time.Now()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// 之前发送的回复收到了端口不可达
+			if isPortUnreachable(err) {
+				continue
+			}
+			return err
+		}
+		if n < stampPacketLen {
+			continue
+		}
+		ttl := 0
+		var cm4 ipv4.ControlMessage
+		if cm4.Parse(oob[:oobn]) == nil && cm4.TTL > 0 {
+			ttl = cm4.TTL
+		}
+		var cm6 ipv6.ControlMessage
+		if cm6.Parse(oob[:oobn]) == nil && cm6.HopLimit > 0 {
+			ttl = cm6.HopLimit
+		}
+
+		// 回复与请求大小相同
+		req := buf[:n]
+		resp := make([]byte, n)
+		binary.BigEndian.PutUint32(resp[0:4], this.nextseq(addr, recvAt))
+		binary.BigEndian.PutUint16(resp[12:14], stampErrorEstimate)
+		binary.BigEndian.PutUint64(resp[16:24], ntpTime(recvAt))
+		copy(resp[24:38], req[0:14])
+		resp[40] = byte(ttl)
+		binary.BigEndian.PutUint64(resp[4:12], ntpTime(time.Now()))
+		conn.WriteToUDP(resp, addr)
+	}
+}
+
+// 返回会话中的下一个序号，会话以客户端地址区分
+func (this *StampReflector) nextseq(addr *net.UDPAddr, now time.Time) uint32 {
+	this.mu.Lock()
+	key := addr.String()
+	if now.Sub(this.lastsweep) > time.Second*10 {
+		this.lastsweep = now
+		for k, v := range this.sessions {
+			if now.Sub(v.last) > stampSessionIdle {
+				delete(this.sessions, k)
+			}
+		}
+	}
+	s := this.sessions[key]
+	isnew := s == nil
+	if isnew {
+		s = &stampSession{}
+		this.sessions[key] = s
+	}
+	seq := s.seq
+	s.seq++
+	s.last = now
+	f := this.OnSession
+	this.mu.Unlock()
+	if isnew && f != nil {
+		f(addr)
+	}
+	return seq
+}
+
+// 监听的地址，未开始运行时返回 nil
+func (this *StampReflector) LocalAddr() net.Addr {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.conn == nil {
+		return nil
+	}
+	return this.conn.LocalAddr()
+}
+
+var (
+	_ IPing       = (*StampPing)(nil)
+	_ IPingResult = (*StampPingResult)(nil)
+)
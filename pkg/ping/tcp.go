@@ -1,21 +1,32 @@
 package ping
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"regexp"
 	"strconv"
 	"time"
 )
 
 type TcpPingResult struct {
+	// 建立连接的时间
 	Time int
 	Err  error
 	IP   net.IP
+
+	// 以下仅在等待响应时有效
+	// 连接建立后到收到第一个字节（或匹配 Expect）的时间
+	FirstByte int
+	// 收到的响应
+	Response []byte
 }
 
 func (this *TcpPingResult) Result() int {
-	return this.Time
+	return this.Time + this.FirstByte
 }
 
 func (this *TcpPingResult) Error() error {
@@ -26,8 +37,63 @@ func (this *TcpPingResult) String() string {
 	if this.Err != nil {
 		return fmt.Sprintf("%s", this.Err)
 	} else {
-		return fmt.Sprintf("%s: time=%d ms", this.IP.String(), this.Time)
+		if this.Response == nil {
+			return fmt.Sprintf("%s: time=%d ms", this.IP.String(), this.Time)
+		}
+		return fmt.Sprintf("%s: connection=%d ms, first byte=%d ms, time=%d ms, response=%q", this.IP.String(), this.Time, this.FirstByte, this.Result(), firstLine(this.Response, 64))
+	}
+}
+
+type PortState int
+
+const (
+	PortOpen PortState = iota
+	// 对方回复了 RST
+	PortClosed
+	// 超时，可能被防火墙丢弃
+	PortFiltered
+	// 其他错误，如本地没有路由。
+	// 系统将 ICMP 不可达报告为 no route to host 等错误，无法与本地错误区分，同样归为此类
+	PortError
+)
+
+func (this PortState) String() string {
+	switch this {
+	case PortOpen:
+		return "open"
+	case PortClosed:
+		return "closed"
+	case PortFiltered:
+		return "filtered"
+	default:
+		return "error"
+	}
+}
+
+// 根据连接的错误判断端口状态
+func (this *TcpPingResult) State() PortState {
+	if this.Err == nil {
+		return PortOpen
+	}
+	if isConnRefused(this.Err) {
+		return PortClosed
+	}
+	var neterr net.Error
+	if errors.Is(this.Err, ErrTimeout) || (errors.As(this.Err, &neterr) && neterr.Timeout()) {
+		return PortFiltered
+	}
+	return PortError
+}
+
+// 响应的第一行，用于显示
+func firstLine(b []byte, maxlen int) string {
+	if i := bytes.IndexAny(b, "\r\n"); i >= 0 {
+		b = b[:i]
+	}
+	if len(b) > maxlen {
+		return string(b[:maxlen]) + "..."
 	}
+	return string(b)
 }
 
 type TcpPing struct {
@@ -35,6 +101,16 @@ type TcpPing struct {
 	Port    uint16
 	Timeout time.Duration
 
+	// IP 头部的 TOS 或 Traffic Class，为 0 时不设置
+	TOS int
+
+	// 连接后发送的数据
+	Send []byte
+	// 等待服务器响应，用于检查服务是否真正可用，如 SSH、SMTP 的欢迎信息
+	WaitResponse bool
+	// 等待与之匹配的响应，设置后即等待响应
+	Expect *regexp.Regexp
+
 	ip net.IP
 }
 
@@ -57,20 +133,86 @@ func (this *TcpPing) PingContext(ctx context.Context) IPingResult {
 		var err error
 		ip, err = LookupFunc(this.host)
 		if err != nil {
-			return &TcpPingResult{0, err, nil}
+			return &TcpPingResult{Err: err}
 		}
 	}
 	dialer := &net.Dialer{
 		Timeout:   this.Timeout,
 		KeepAlive: -1,
+		Control:   tosControl(this.TOS),
 	}
 	t0 := time.Now()
 	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.FormatUint(uint64(this.Port), 10)))
 	if err != nil {
-		return &TcpPingResult{0, err, nil}
+		// 收到 RST 的时间同样反映了延迟
+		return &TcpPingResult{Time: int(time.Since(t0).Milliseconds()), Err: err}
 	}
 	defer conn.Close()
-	return &TcpPingResult{int(time.Since(t0).Milliseconds()), nil, ip}
+	t1 := time.Now()
+	result := &TcpPingResult{Time: int(t1.Sub(t0).Milliseconds()), IP: ip}
+	if len(this.Send) == 0 && !this.WaitResponse && this.Expect == nil {
+		return result
+	}
+
+	deadline := t1.Add(this.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if len(this.Send) > 0 {
+		if _, err := conn.Write(this.Send); err != nil {
+			return &TcpPingResult{Err: this.wrapError(ctx, ip, err)}
+		}
+		if !this.WaitResponse && this.Expect == nil {
+			return result
+		}
+	}
+	resp, err := readResponse(conn, this.Expect)
+	if err != nil {
+		return &TcpPingResult{Err: this.wrapError(ctx, ip, err)}
+	}
+	result.FirstByte = int(time.Since(t1).Milliseconds())
+	result.Response = resp
+	return result
+}
+
+func (this *TcpPing) wrapError(ctx context.Context, ip net.IP, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var neterr net.Error
+	if errors.As(err, &neterr) && neterr.Timeout() {
+		return fmt.Errorf("%s: %w", ip.String(), ErrTimeout)
+	}
+	return err
+}
+
+// 读取响应，直到收到任意数据，或与 expect 匹配
+func readResponse(conn net.Conn, expect *regexp.Regexp) ([]byte, error) {
+	const maxlen = 65536
+	var resp []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		resp = append(resp, buf[:n]...)
+		if n > 0 && (expect == nil || expect.Match(resp)) {
+			return resp, nil
+		}
+		if err != nil {
+			if len(resp) > 0 && err == io.EOF {
+				return nil, fmt.Errorf("unexpected response: %q", firstLine(resp, 64))
+			}
+			return nil, err
+		}
+		if len(resp) >= maxlen {
+			return nil, fmt.Errorf("unexpected response: %q", firstLine(resp, 64))
+		}
+	}
 }
 
 func NewTcpPing(host string, port uint16, timeout time.Duration) *TcpPing {
@@ -0,0 +1,346 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// 发送 TCP SYN 的路由追踪，用于丢弃 ICMP echo 但允许 TCP 的路径，收到 SYN-ACK 或 RST 即到达目标。
+// 特权模式下使用原始套接字发送 SYN；非特权模式下以指定的 TTL 发起连接，仅 Linux 能得到途经路由器的地址
+type TcpTrace struct {
+	host    string
+	Port    uint16
+	Timeout time.Duration
+
+	MaxHops    int
+	Probes     int
+	Privileged bool
+}
+
+func (this *TcpTrace) SetHost(host string) {
+	this.host = host
+}
+
+func (this *TcpTrace) Host() string {
+	return this.host
+}
+
+func NewTcpTrace(host string, port uint16, timeout time.Duration) *TcpTrace {
+	return &TcpTrace{
+		host:    host,
+		Port:    port,
+		Timeout: timeout,
+		MaxHops: 30,
+		Probes:  3,
+	}
+}
+
+func (this *TcpTrace) Trace(f func(*TraceHop)) error {
+	return this.TraceContext(context.Background(), f)
+}
+
+// 每完成一跳调用一次 f，到达目标或超过最大跳数时返回
+func (this *TcpTrace) TraceContext(ctx context.Context, f func(*TraceHop)) error {
+	ip := net.ParseIP(this.host)
+	if ip == nil {
+		var err error
+		ip, err = LookupFunc(this.host)
+		if err != nil {
+			return err
+		}
+	}
+	if !this.Privileged {
+		return trace(ctx, this.MaxHops, this.Probes, f, func(ctx context.Context, ttl int) (*IcmpPingResult, error) {
+			return this.connprobe(ctx, ip, ttl), nil
+		})
+	}
+	s, err := newTcpSynSender(ip, this.Port)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	return trace(ctx, this.MaxHops, this.Probes, f, func(ctx context.Context, ttl int) (*IcmpPingResult, error) {
+		return s.probe(ctx, ttl, this.Timeout)
+	})
+}
+
+func (this *TcpTrace) connprobe(ctx context.Context, ip net.IP, ttl int) *IcmpPingResult {
+	dialer := &net.Dialer{
+		Timeout:   this.Timeout,
+		KeepAlive: -1,
+	}
+	t0 := time.Now()
+	conn, msg, err := dialTTL(ctx, dialer, net.JoinHostPort(ip.String(), strconv.FormatUint(uint64(this.Port), 10)), !isIPv4(ip), ttl)
+	r := &IcmpPingResult{Time: int(time.Since(t0).Milliseconds()), TTL: -1}
+	if err == nil {
+		conn.Close()
+		r.IP = ip
+		return r
+	}
+	if isConnRefused(err) {
+		r.IP = ip
+		return r
+	}
+	if msg != nil && msg.peer != nil {
+		r.IP = msg.peer
+		r.Err = icmpError(msg, msg.peer)
+		return r
+	}
+	var neterr net.Error
+	if ctx.Err() == nil && errors.As(err, &neterr) && neterr.Timeout() {
+		err = fmt.Errorf("%s: %w", ip.String(), ErrTimeout)
+	}
+	r.Err = err
+	return r
+}
+
+const (
+	tcpFlagSyn = 0x02
+	tcpFlagRst = 0x04
+	tcpFlagAck = 0x10
+)
+
+type tcpSynProbe struct {
+	sendAt time.Time
+	reply  chan *IcmpPingResult
+}
+
+// 使用原始套接字发送 SYN，并接收 SYN-ACK、RST 及途经路由器的 ICMP 差错报文，按序号分发
+type tcpSynSender struct {
+	dst, src     net.IP
+	isipv6       bool
+	sport, dport uint16
+
+	tcp  net.PacketConn
+	icmp net.PacketConn
+	p4   *ipv4.PacketConn
+	p6   *ipv6.PacketConn
+
+	mu      sync.Mutex
+	probes  map[uint32]*tcpSynProbe
+	nextseq uint32
+}
+
+func newTcpSynSender(dst net.IP, port uint16) (*tcpSynSender, error) {
+	s := &tcpSynSender{
+		dst:     dst,
+		isipv6:  !isIPv4(dst),
+		dport:   port,
+		sport:   uint16(32768 + rand.IntN(28232)),
+		probes:  make(map[uint32]*tcpSynProbe),
+		nextseq: rand.Uint32(),
+	}
+	// 计算校验和需要源地址，由系统根据路由选择
+	c, err := net.Dial("udp", net.JoinHostPort(dst.String(), strconv.FormatUint(uint64(port), 10)))
+	if err != nil {
+		return nil, err
+	}
+	s.src = c.LocalAddr().(*net.UDPAddr).IP
+	c.Close()
+
+	tcpnet, icmpnet, icmpaddr := "ip4:tcp", "ip4:icmp", "0.0.0.0"
+	if s.isipv6 {
+		tcpnet, icmpnet, icmpaddr = "ip6:tcp", "ip6:ipv6-icmp", "::"
+	}
+	s.tcp, err = net.ListenPacket(tcpnet, s.src.String())
+	if err != nil {
+		return nil, err
+	}
+	s.icmp, err = net.ListenPacket(icmpnet, icmpaddr)
+	if err != nil {
+		s.tcp.Close()
+		return nil, err
+	}
+	if s.isipv6 {
+		s.p6 = ipv6.NewPacketConn(s.tcp)
+	} else {
+		s.p4 = ipv4.NewPacketConn(s.tcp)
+		s.p4.SetControlMessage(ipv4.FlagTTL, true)
+	}
+	go s.recvtcp()
+	go s.recvicmp()
+	return s, nil
+}
+
+func (this *tcpSynSender) close() {
+	this.tcp.Close()
+	this.icmp.Close()
+}
+
+func (this *tcpSynSender) probe(ctx context.Context, ttl int, timeout time.Duration) (*IcmpPingResult, error) {
+	probe := &tcpSynProbe{reply: make(chan *IcmpPingResult, 1)}
+	this.mu.Lock()
+	seq := this.nextseq
+	this.nextseq++
+	this.probes[seq] = probe
+	this.mu.Unlock()
+	defer func() {
+		this.mu.Lock()
+		delete(this.probes, seq)
+		this.mu.Unlock()
+	}()
+
+	var err error
+	if this.isipv6 {
+		err = this.p6.SetHopLimit(ttl)
+	} else {
+		err = this.p4.SetTTL(ttl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := tcpSyn(this.src, this.dst, this.sport, this.dport, seq)
+	this.mu.Lock()
+	probe.sendAt = time.Now()
+	this.mu.Unlock()
+	if _, err := this.tcp.WriteTo(b, &net.IPAddr{IP: this.dst}); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-probe.reply:
+		return r, nil
+	case <-timer.C:
+		return &IcmpPingResult{Err: fmt.Errorf("%s: %w", this.dst.String(), ErrTimeout)}, nil
+	case <-ctx.Done():
+		return &IcmpPingResult{Err: ctx.Err()}, nil
+	}
+}
+
+func (this *tcpSynSender) deliver(seq uint32, r *IcmpPingResult, recvAt time.Time) {
+	this.mu.Lock()
+	probe := this.probes[seq]
+	if probe == nil {
+		this.mu.Unlock()
+		return
+	}
+	delete(this.probes, seq)
+	r.Time = int(recvAt.Sub(probe.sendAt).Milliseconds())
+	this.mu.Unlock()
+	probe.reply <- r
+}
+
+// 接收目标回复的 SYN-ACK 或 RST，确认号为发送的序号加一
+func (this *tcpSynSender) recvtcp() {
+	buf := make([]byte, 65536)
+	for {
+		ttl := -1
+		var n int
+		var peer net.Addr
+		var err error
+		if this.isipv6 {
+			n, _, peer, err = this.p6.ReadFrom(buf)
+		} else {
+			var cm *ipv4.ControlMessage
+			n, cm, peer, err = this.p4.ReadFrom(buf)
+			if cm != nil {
+				ttl = cm.TTL
+			}
+		}
+		if err != nil {
+			return
+		}
+		recvAt := time.Now()
+		b := buf[:n]
+		if len(b) < 20 || binary.BigEndian.Uint16(b[0:2]) != this.dport || binary.BigEndian.Uint16(b[2:4]) != this.sport {
+			continue
+		}
+		if addr, ok := peer.(*net.IPAddr); !ok || !addr.IP.Equal(this.dst) {
+			continue
+		}
+		flags := b[13]
+		if flags&tcpFlagRst == 0 && flags&(tcpFlagSyn|tcpFlagAck) != tcpFlagSyn|tcpFlagAck {
+			continue
+		}
+		ack := binary.BigEndian.Uint32(b[8:12])
+		this.deliver(ack-1, &IcmpPingResult{IP: this.dst, TTL: ttl}, recvAt)
+	}
+}
+
+// 接收途经路由器的差错报文，其中包含原始 SYN 的端口及序号
+func (this *tcpSynSender) recvicmp() {
+	proto := 1
+	if this.isipv6 {
+		proto = 58
+	}
+	buf := make([]byte, 65536)
+	for {
+		n, peer, err := this.icmp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		recvAt := time.Now()
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		m := parserecvmsg(this.isipv6, msg, buf[:n])
+		if m.msgtype < 2 || m.hdr == nil || !m.dst.Equal(this.dst) {
+			continue
+		}
+		hdr := m.hdr
+		if binary.BigEndian.Uint16(hdr[0:2]) != this.sport || binary.BigEndian.Uint16(hdr[2:4]) != this.dport {
+			continue
+		}
+		addr, ok := peer.(*net.IPAddr)
+		if !ok {
+			continue
+		}
+		r := &IcmpPingResult{IP: addr.IP, Err: icmpError(m, addr.IP)}
+		this.deliver(binary.BigEndian.Uint32(hdr[4:8]), r, recvAt)
+	}
+}
+
+// 构造 SYN 报文，IP 头部由系统添加
+func tcpSyn(src, dst net.IP, sport, dport uint16, seq uint32) []byte {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint16(b[0:2], sport)
+	binary.BigEndian.PutUint16(b[2:4], dport)
+	binary.BigEndian.PutUint32(b[4:8], seq)
+	b[12] = 6 << 4
+	b[13] = tcpFlagSyn
+	binary.BigEndian.PutUint16(b[14:16], 65535)
+	// MSS 选项，部分中间设备会丢弃不带选项的 SYN
+	copy(b[20:], []byte{2, 4, 0x05, 0xb4})
+
+	// 伪首部
+	var pseudo []byte
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		pseudo = append(pseudo, src4...)
+		pseudo = append(pseudo, dst4...)
+		pseudo = append(pseudo, 0, 6, 0, byte(len(b)))
+	} else {
+		pseudo = append(pseudo, src.To16()...)
+		pseudo = append(pseudo, dst.To16()...)
+		pseudo = append(pseudo, 0, 0, 0, byte(len(b)), 0, 0, 0, 6)
+	}
+	binary.BigEndian.PutUint16(b[16:18], checksum(append(pseudo, b...)))
+	return b
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
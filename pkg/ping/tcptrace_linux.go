@@ -0,0 +1,50 @@
+//go:build linux
+
+package ping
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// 以指定的 TTL 发起连接，连接因差错报文失败时，从错误队列中取出该报文。
+// 连接失败后套接字会被关闭，因此先复制一份描述符
+func dialTTL(ctx context.Context, d *net.Dialer, address string, isipv6 bool, ttl int) (net.Conn, *icmpMessage, error) {
+	dupfd := -1
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		var operr error
+		err := c.Control(func(fd uintptr) {
+			if operr = setsockoptTTL(fd, isipv6, ttl); operr != nil {
+				return
+			}
+			if isipv6 {
+				operr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, syscall.IPV6_RECVERR, 1)
+			} else {
+				operr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_RECVERR, 1)
+			}
+			if operr != nil {
+				return
+			}
+			dupfd, operr = syscall.Dup(int(fd))
+			if operr == nil {
+				syscall.CloseOnExec(dupfd)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return operr
+	}
+	conn, err := d.DialContext(ctx, "tcp", address)
+	var msg *icmpMessage
+	if dupfd >= 0 {
+		if err != nil {
+			if msgs, _ := recvErrQueue(dupfd); len(msgs) > 0 {
+				msg = msgs[len(msgs)-1]
+			}
+		}
+		syscall.Close(dupfd)
+	}
+	return conn, msg, err
+}
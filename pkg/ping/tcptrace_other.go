@@ -0,0 +1,25 @@
+//go:build !linux
+
+package ping
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// 以指定的 TTL 发起连接，无法得到差错报文的来源
+func dialTTL(ctx context.Context, d *net.Dialer, address string, isipv6 bool, ttl int) (net.Conn, *icmpMessage, error) {
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		var operr error
+		err := c.Control(func(fd uintptr) {
+			operr = setsockoptTTL(fd, isipv6, ttl)
+		})
+		if err != nil {
+			return err
+		}
+		return operr
+	}
+	conn, err := d.DialContext(ctx, "tcp", address)
+	return conn, nil, err
+}
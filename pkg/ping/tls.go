@@ -43,6 +43,7 @@ type TlsPing struct {
 	TlsVersion uint16
 	Insecure   bool
 	IP         net.IP
+	TOS        int
 }
 
 func (this *TlsPing) Ping() IPingResult {
@@ -62,6 +63,7 @@ func (this *TlsPing) PingContext(ctx context.Context) IPingResult {
 	dialer := &net.Dialer{
 		Timeout:   this.ConnectionTimeout,
 		KeepAlive: -1,
+		Control:   tosControl(this.TOS),
 	}
 	t0 := time.Now()
 	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.FormatUint(uint64(this.Port), 10)))
@@ -0,0 +1,62 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// 用于 net.Dialer 及 net.ListenConfig，设置连接的 TOS 或 Traffic Class，tos 为 0 时不设置
+func tosControl(tos int) func(network, address string, c syscall.RawConn) error {
+	if tos <= 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var operr error
+		err := c.Control(func(fd uintptr) {
+			operr = setsockoptTOS(fd, strings.HasSuffix(network, "6"), tos)
+		})
+		if err != nil {
+			return err
+		}
+		return operr
+	}
+}
+
+// 用于 QUIC 的 UDP 连接。
+// 隐藏 ReadMsgUDP 及 WriteMsgUDP，否则 quic-go 会通过控制消息设置 ECN，覆盖掉套接字的 TOS
+type tosUDPConn struct {
+	net.PacketConn
+	conn *net.UDPConn
+}
+
+func (this *tosUDPConn) SetReadBuffer(bytes int) error {
+	return this.conn.SetReadBuffer(bytes)
+}
+
+func (this *tosUDPConn) SetWriteBuffer(bytes int) error {
+	return this.conn.SetWriteBuffer(bytes)
+}
+
+func (this *tosUDPConn) SyscallConn() (syscall.RawConn, error) {
+	return this.conn.SyscallConn()
+}
+
+// 打开设置了 TOS 的 UDP 连接，并解析目标地址
+func listenUDPTOS(addr string, tos int) (net.PacketConn, *net.UDPAddr, error) {
+	udpaddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	network := "udp4"
+	if !isIPv4(udpaddr.IP) {
+		network = "udp6"
+	}
+	lc := net.ListenConfig{Control: tosControl(tos)}
+	conn, err := lc.ListenPacket(context.Background(), network, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tosUDPConn{conn, conn.(*net.UDPConn)}, udpaddr, nil
+}
@@ -0,0 +1,148 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type TraceHop struct {
+	TTL int
+	// 每个探测的结果，顺序与发送顺序一致
+	Probes []*IcmpPingResult
+}
+
+// 是否已到达终点，收到 echo 回复或目标不可达时不再继续
+func (this *TraceHop) Reached() bool {
+	for _, r := range this.Probes {
+		if r.Responder() != nil && !errors.Is(r.Err, ErrTimeExceeded) {
+			return true
+		}
+	}
+	return false
+}
+
+// 丢包数
+func (this *TraceHop) Lost() int {
+	n := 0
+	for _, r := range this.Probes {
+		if r.Responder() == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (this *TraceHop) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%2d", this.TTL)
+	last := ""
+	for _, r := range this.Probes {
+		if r.Responder() == nil {
+			b.WriteString("  *")
+			continue
+		}
+		// 同一跳可能经由不同的路由器回复，地址变化时才输出
+		if ip := r.IP.String(); ip != last {
+			fmt.Fprintf(&b, "  %s", ip)
+			last = ip
+		}
+		fmt.Fprintf(&b, "  %d ms", r.Time)
+		if errors.Is(r.Err, ErrDestinationUnreachable) {
+			b.WriteString(" !")
+		}
+	}
+	if len(this.Probes) > 0 {
+		fmt.Fprintf(&b, "  (%d%% loss)", 100*this.Lost()/len(this.Probes))
+	}
+	return b.String()
+}
+
+// 逐跳增加 TTL 发送 ICMP echo 请求，得到经过的路由器
+type IcmpTrace struct {
+	host    string
+	Timeout time.Duration
+
+	MaxHops    int
+	Probes     int
+	Privileged bool
+	Size       int
+
+	// 共享的 ICMP 连接，为空时使用自己的连接
+	Engine *IcmpEngine
+}
+
+func (this *IcmpTrace) SetHost(host string) {
+	this.host = host
+}
+
+func (this *IcmpTrace) Host() string {
+	return this.host
+}
+
+func NewIcmpTrace(host string, timeout time.Duration) *IcmpTrace {
+	return &IcmpTrace{
+		host:    host,
+		Timeout: timeout,
+		MaxHops: 30,
+		Probes:  3,
+		Size:    32,
+	}
+}
+
+func (this *IcmpTrace) Trace(f func(*TraceHop)) error {
+	return this.TraceContext(context.Background(), f)
+}
+
+// 每完成一跳调用一次 f，到达目标或超过最大跳数时返回
+func (this *IcmpTrace) TraceContext(ctx context.Context, f func(*TraceHop)) error {
+	// 只解析一次，确保每一跳的目标相同
+	p := NewIcmpPing(this.host, this.Timeout)
+	ip, _, err := p.parseip()
+	if err != nil {
+		return err
+	}
+	p.SetHost(ip.String())
+	p.Privileged = this.Privileged
+	p.Size = this.Size
+	p.Engine = this.Engine
+	defer p.Close()
+
+	return trace(ctx, this.MaxHops, this.Probes, f, func(ctx context.Context, ttl int) (*IcmpPingResult, error) {
+		p.TTL = ttl
+		r := p.PingContext(ctx).(*IcmpPingResult)
+		// 除超时外的其他错误，如无法创建连接，继续探测也没有意义
+		if r.Err != nil && r.Responder() == nil && !errors.Is(r.Err, ErrTimeout) && ctx.Err() == nil {
+			return nil, r.Err
+		}
+		return r, nil
+	})
+}
+
+// 逐跳调用 probe 发送探测，同一跳的探测依次发送，并发发送时路由器可能因限速而丢弃部分差错报文。
+// probe 返回错误时停止
+func trace(ctx context.Context, maxhops, probes int, f func(*TraceHop), probe func(context.Context, int) (*IcmpPingResult, error)) error {
+	for ttl := 1; ttl <= maxhops; ttl++ {
+		hop := &TraceHop{
+			TTL:    ttl,
+			Probes: make([]*IcmpPingResult, probes),
+		}
+		for i := range hop.Probes {
+			r, err := probe(ctx, ttl)
+			if err != nil {
+				return err
+			}
+			hop.Probes[i] = r
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f(hop)
+		if hop.Reached() {
+			return nil
+		}
+	}
+	return nil
+}
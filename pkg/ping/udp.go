@@ -0,0 +1,146 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// 目标返回了 ICMP 端口不可达
+var ErrPortClosed = errors.New("port closed")
+
+type UdpPingResult struct {
+	Time   int
+	Length int
+	Err    error
+	IP     net.IP
+}
+
+func (this *UdpPingResult) Result() int {
+	return this.Time
+}
+
+func (this *UdpPingResult) Error() error {
+	return this.Err
+}
+
+func (this *UdpPingResult) String() string {
+	if this.Err != nil {
+		return fmt.Sprintf("%s", this.Err)
+	} else {
+		return fmt.Sprintf("%s: length=%d, time=%d ms", this.IP.String(), this.Length, this.Time)
+	}
+}
+
+// 发送一个 UDP 包，等待任意回复或与 Expect 匹配的回复
+type UdpPing struct {
+	host    string
+	Port    uint16
+	Timeout time.Duration
+
+	// 以下参数全部为可选
+	Payload []byte
+	// 忽略不匹配的回复，继续等待直到超时
+	Expect *regexp.Regexp
+	TOS    int
+
+	ip net.IP
+}
+
+func (this *UdpPing) SetHost(host string) {
+	this.host = host
+	this.ip = net.ParseIP(host)
+}
+
+func (this *UdpPing) Host() string {
+	return this.host
+}
+
+func (this *UdpPing) Ping() IPingResult {
+	return this.PingContext(context.Background())
+}
+
+func (this *UdpPing) PingContext(ctx context.Context) IPingResult {
+	ip := cloneIP(this.ip)
+	if ip == nil {
+		var err error
+		ip, err = LookupFunc(this.host)
+		if err != nil {
+			return this.errorResult(err)
+		}
+	}
+	dialer := &net.Dialer{
+		Timeout: this.Timeout,
+		Control: tosControl(this.TOS),
+	}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(ip.String(), strconv.FormatUint(uint64(this.Port), 10)))
+	if err != nil {
+		return this.errorResult(err)
+	}
+	defer conn.Close()
+	if err := reportPortUnreachable(conn.(*net.UDPConn)); err != nil {
+		return this.errorResult(err)
+	}
+	deadline := time.Now().Add(this.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	t0 := time.Now()
+	if _, err := conn.Write(this.Payload); err != nil {
+		return this.errorResult(this.wrapError(ctx, ip, err))
+	}
+	buf := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return this.errorResult(this.wrapError(ctx, ip, err))
+		}
+		if this.Expect == nil || this.Expect.Match(buf[:n]) {
+			return &UdpPingResult{int(time.Since(t0).Milliseconds()), n, nil, ip}
+		}
+	}
+}
+
+func (this *UdpPing) wrapError(ctx context.Context, ip net.IP, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if isPortUnreachable(err) {
+		return fmt.Errorf("%s: %w", ip.String(), ErrPortClosed)
+	}
+	var neterr net.Error
+	if errors.As(err, &neterr) && neterr.Timeout() {
+		return fmt.Errorf("%s: %w", ip.String(), ErrTimeout)
+	}
+	return err
+}
+
+func (this *UdpPing) errorResult(err error) *UdpPingResult {
+	r := &UdpPingResult{}
+	r.Err = err
+	return r
+}
+
+func NewUdpPing(host string, port uint16, timeout time.Duration) *UdpPing {
+	return &UdpPing{
+		host:    host,
+		Port:    port,
+		Timeout: timeout,
+		ip:      net.ParseIP(host),
+	}
+}
+
+var (
+	_ IPing       = (*UdpPing)(nil)
+	_ IPingResult = (*UdpPingResult)(nil)
+)
@@ -1,10 +1,21 @@
 package pping_test
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/quic-go/quic-go/http3"
 	"github.com/wzv5/pping/pkg/ping"
 )
 
@@ -108,3 +119,391 @@ func TestHttpIPv6(t *testing.T) {
 		t.Fatal(result.Error())
 	}
 }
+
+func TestDnsQuic(t *testing.T) {
+	p := ping.NewDnsPing("dns.adguard-dns.com", time.Second*5)
+	p.Net = "quic"
+	p.Port = 853
+	result := p.Ping()
+	if result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+}
+
+func TestDns_expect(t *testing.T) {
+	p := ping.NewDnsPing("223.5.5.5", time.Second*3)
+	p.Type = "A"
+	p.Domain = HOST
+	p.ExpectRcode = "NOERROR"
+	result := p.Ping()
+	if result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+	if len(result.(*ping.DnsPingResult).Answer) == 0 {
+		t.Fatal("no answer")
+	}
+
+	p.Domain = "nonexistent.invalid"
+	result = p.Ping()
+	if result.Error() == nil {
+		t.Fatal("NXDOMAIN should be an error")
+	}
+}
+
+func TestDns_edns(t *testing.T) {
+	p := ping.NewDnsPing("1.1.1.1", time.Second*3)
+	p.NSID = true
+	p.DNSSEC = true
+	p.Cookie = true
+	result := p.Ping()
+	if result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+	if result.(*ping.DnsPingResult).NSID == "" {
+		t.Fatal("no NSID")
+	}
+}
+
+func TestDns_random(t *testing.T) {
+	p := ping.NewDnsPing("223.5.5.5", time.Second*3)
+	p.Type = "A"
+	p.Domain = HOST
+	p.RandomPrefix = true
+	result := p.Ping()
+	if result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+	if !strings.HasSuffix(result.(*ping.DnsPingResult).Name, "."+HOST+".") {
+		t.Fatal(result.(*ping.DnsPingResult).Name)
+	}
+}
+
+func TestDnsZone(t *testing.T) {
+	z := ping.NewDnsZoneCheck(ping.NewDnsPing("223.5.5.5", time.Second*3), "microsoft.com")
+	servers, err := z.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range servers {
+		if !s.OK() {
+			t.Error(s)
+		}
+	}
+}
+
+func TestIcmp_session(t *testing.T) {
+	p := ping.NewIcmpPing("127.0.0.1", time.Second*1)
+	p.Privileged = true
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		result := p.Ping()
+		if result.Error() != nil {
+			t.Fatal(result.Error())
+		}
+		if seq := result.(*ping.IcmpPingResult).Seq; seq != i {
+			t.Fatalf("seq = %d, want %d", seq, i)
+		}
+	}
+	if stats := p.Statistics(); stats.Duplicates != 0 || stats.Late != 0 {
+		t.Fatal(stats)
+	}
+}
+
+func TestIcmpEngine(t *testing.T) {
+	engine := ping.NewIcmpEngine(true)
+	defer engine.Close()
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			p := ping.NewIcmpPing(host, time.Second*1)
+			p.Engine = engine
+			for j := 0; j < 3; j++ {
+				result := p.Ping()
+				if result.Error() != nil {
+					t.Error(result.Error())
+					return
+				}
+				if ip := result.(*ping.IcmpPingResult).IP.String(); ip != host {
+					t.Errorf("reply from %s, want %s", ip, host)
+				}
+			}
+		}(fmt.Sprintf("127.0.0.%d", i))
+	}
+	wg.Wait()
+}
+
+func TestIcmp_context(t *testing.T) {
+	p := ping.NewIcmpPing("203.0.113.1", time.Second*5)
+	p.Privileged = true
+	defer p.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	t0 := time.Now()
+	result := p.PingContext(ctx)
+	if time.Since(t0) > time.Second {
+		t.Fatalf("context ignored, took %v: %v", time.Since(t0), result)
+	}
+}
+
+func TestIcmpTrace(t *testing.T) {
+	p := ping.NewIcmpTrace(HOST, time.Second*1)
+	var hops []*ping.TraceHop
+	err := p.Trace(func(hop *ping.TraceHop) {
+		t.Log(hop)
+		hops = append(hops, hop)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) == 0 || !hops[len(hops)-1].Reached() {
+		t.Fatal("destination not reached")
+	}
+}
+
+func TestTcpTrace(t *testing.T) {
+	p := ping.NewTcpTrace(HOST, 443, time.Second*1)
+	var last *ping.TraceHop
+	err := p.Trace(func(hop *ping.TraceHop) {
+		t.Log(hop)
+		last = hop
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || !last.Reached() {
+		t.Fatal("destination not reached")
+	}
+}
+
+func TestIcmp_df(t *testing.T) {
+	p := ping.NewIcmpPing(HOST, time.Second*1)
+	p.DontFragment = true
+	p.Size = 65000
+	result := p.Ping()
+	var fe *ping.FragmentationNeededError
+	if !errors.As(result.Error(), &fe) {
+		t.Fatal(result)
+	}
+}
+
+func TestIcmpMtu(t *testing.T) {
+	p := ping.NewIcmpMtu(HOST, time.Second*1)
+	mtu, err := p.Discover(func(probe *ping.MtuProbe) {
+		t.Log(probe)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtu < 576 {
+		t.Fatal(mtu)
+	}
+}
+
+func TestTos(t *testing.T) {
+	// EF
+	tp := ping.NewTcpPing(HOST, 80, time.Second*3)
+	tp.TOS = 46 << 2
+	if result := tp.Ping(); result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+	ip := ping.NewIcmpPing(HOST, time.Second*1)
+	ip.TOS = 46 << 2
+	if result := ip.Ping(); result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+}
+
+func TestIcmp_pattern(t *testing.T) {
+	p := ping.NewIcmpPing(HOST, time.Second*1)
+	p.Pattern = []byte{0xff, 0x00}
+	p.Timestamp = true
+	p.Size = 64
+	result := p.Ping()
+	if result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+}
+
+func TestUdp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte("noise"), addr)
+			conn.WriteTo(append([]byte("pong "), buf[:n]...), addr)
+		}
+	}()
+	port := uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+	p := ping.NewUdpPing("127.0.0.1", port, time.Second*1)
+	p.Payload = []byte("ping")
+	p.Expect = regexp.MustCompile("^pong ping$")
+	result := p.Ping()
+	if result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+	conn.Close()
+	result = p.Ping()
+	if !errors.Is(result.Error(), ping.ErrPortClosed) {
+		t.Fatal(result)
+	}
+}
+
+func TestStamp(t *testing.T) {
+	r := ping.NewStampReflector("127.0.0.1:0")
+	if err := r.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Serve(ctx)
+
+	p := ping.NewStampPing("127.0.0.1", time.Second*1)
+	p.Port = uint16(r.LocalAddr().(*net.UDPAddr).Port)
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		result := p.Ping()
+		if result.Error() != nil {
+			t.Fatal(result.Error())
+		}
+		t.Log(result)
+	}
+	stats := p.Statistics()
+	if stats.Sent != 3 || stats.Received != 3 || stats.ForwardLoss != 0 || stats.ReverseLoss != 0 {
+		t.Fatalf("%+v", stats)
+	}
+}
+
+func TestTcp_expect(t *testing.T) {
+	p := ping.NewTcpPing(HOST, 80, time.Second*3)
+	p.Send = []byte("HEAD / HTTP/1.0\r\nHost: " + HOST + "\r\n\r\n")
+	p.Expect = regexp.MustCompile("^HTTP/1")
+	result := p.Ping()
+	if result.Error() != nil {
+		t.Fatal(result.Error())
+	}
+	t.Log(result)
+}
+
+func TestTcp_state(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	p := ping.NewTcpPing("127.0.0.1", port, time.Second*1)
+	if state := p.Ping().(*ping.TcpPingResult).State(); state != ping.PortOpen {
+		t.Fatal(state)
+	}
+	l.Close()
+	if state := p.Ping().(*ping.TcpPingResult).State(); state != ping.PortClosed {
+		t.Fatal(state)
+	}
+}
+
+func TestHttp_maxbytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1<<20))
+	}))
+	defer srv.Close()
+	p := ping.NewHttpPing("GET", srv.URL, time.Second*3)
+	p.MaxBytes = 1000
+	result := p.Ping().(*ping.HttpPingResult)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if result.Length != 1000 || !result.Truncated {
+		t.Fatal(result)
+	}
+	p.MaxBytes = 0
+	result = p.Ping().(*ping.HttpPingResult)
+	if result.Err != nil || result.Length != 1<<20 || result.Truncated || result.Speed <= 0 {
+		t.Fatal(result)
+	}
+}
+
+func TestHttp_upload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		fmt.Fprint(w, n)
+	}))
+	defer srv.Close()
+	p := ping.NewHttpPing("POST", srv.URL, time.Second*3)
+	p.UploadSize = 1 << 20
+	result := p.Ping().(*ping.HttpPingResult)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if result.Uploaded != 1<<20 || result.UploadSpeed <= 0 {
+		t.Fatal(result)
+	}
+	t.Log(result)
+}
+
+func TestHttp_auto(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", fmt.Sprintf(`h3=":%d"; ma=60`, udp.LocalAddr().(*net.UDPAddr).Port))
+	})
+	srv := httptest.NewTLSServer(h)
+	defer srv.Close()
+	h3srv := &http3.Server{
+		Handler:   h,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: srv.TLS.Certificates}),
+	}
+	go h3srv.Serve(udp)
+	defer h3srv.Close()
+
+	p := ping.NewHttpPing("GET", srv.URL, time.Second*3)
+	p.Insecure = true
+	p.AutoHttp3 = true
+	for i, proto := range []string{"HTTP/1.1", "HTTP/3.0", "HTTP/3.0"} {
+		result := p.Ping().(*ping.HttpPingResult)
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if result.Proto != proto || (i < 2) != (result.Note != "") {
+			t.Fatal(result)
+		}
+		t.Log(result)
+	}
+}
+
+func TestHttp_edge(t *testing.T) {
+	headers := []map[string]string{
+		{"CF-Ray": "8a1b2c3d4e5f6a7b-SJC", "CF-Cache-Status": "DYNAMIC"},
+		{"X-Amz-Cf-Pop": "NRT57-P2", "X-Cache": "Hit from cloudfront"},
+		{"X-Served-By": "cache-iad-kiad7000074-IAD, cache-lax-klax1234-LAX", "X-Cache": "HIT, MISS"},
+	}
+	expect := [][2]string{{"SJC", "DYNAMIC"}, {"NRT57-P2", "HIT"}, {"LAX", "MISS"}}
+	var i int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range headers[i] {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("X-Other", "1")
+	}))
+	defer srv.Close()
+	p := ping.NewHttpPing("GET", srv.URL, time.Second*3)
+	for i = range headers {
+		result := p.Ping().(*ping.HttpPingResult)
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if result.Pop() != expect[i][0] || result.CacheStatus() != expect[i][1] || result.Header.Get("X-Other") != "" {
+			t.Fatal(result, result.Header)
+		}
+	}
+}